@@ -1,59 +1,151 @@
 package main
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/signal"
-	"syscall"
+	"strings"
 
+	"github.com/mush1e/traSH/config"
 	"github.com/mush1e/traSH/internal/command"
 	"github.com/mush1e/traSH/internal/io"
+	"github.com/mush1e/traSH/internal/parser"
+	"golang.org/x/term"
 )
 
+const usage = "usage: traSH [-c command [name [arg ...]]] [script [arg ...]]"
+
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	sh := command.NewShell()
+	args := os.Args[1:]
 
+	switch {
+	case len(args) > 0 && args[0] == "-c":
+		// traSH -c 'commands' [name [arg ...]], as used by make, CI
+		// runners and friends
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "traSH: -c: option requires an argument")
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		if len(args) > 2 {
+			sh.SetArg0(args[2])
+			sh.SetParams(args[3:])
+		}
+		os.Exit(sh.RunScript(strings.NewReader(args[1]), ""))
+
+	case len(args) > 0 && args[0] == "--":
+		args = args[1:]
+		if len(args) > 0 {
+			os.Exit(runFile(sh, args))
+		}
+
+	case len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-":
+		fmt.Fprintf(os.Stderr, "traSH: %s: invalid option\n", args[0])
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+
+	case len(args) > 0 && args[0] != "-":
+		os.Exit(runFile(sh, args))
+
+	case len(args) > 0:
+		// "-" reads commands from stdin, with the rest as arguments
+		sh.SetParams(args[1:])
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		// Commands piped in, as in `echo 'ls' | traSH`
+		os.Exit(sh.RunScript(os.Stdin, ""))
+	}
+	os.Exit(interactive(sh))
+}
+
+// runFile runs the script named by args[0] with the rest of args as its
+// positional parameters
+func runFile(sh *command.Shell, args []string) int {
+	f, err := os.Open(args[0])
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		fmt.Fprintf(os.Stderr, "traSH: %s: %v\n", args[0], err)
+		return 127
+	}
+	defer f.Close()
+
+	sh.SetArg0(args[0])
+	sh.SetParams(args[1:])
+	return sh.RunScript(f, args[0])
+}
+
+// interactive reads commands from the terminal until exit, returning the
+// status to exit with
+func interactive(sh *command.Shell) int {
 	io.WriteHeader(os.Stdout)
-	userExit := make(chan struct{})
-
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				cmd := command.ParseCommand(io.ReadUserInput(io.BuildPrompt()))
-
-				if cmd.GetCommand() == "exit" {
-					close(userExit)
-					return
-				}
-
-				if err := command.HandleCommand(cmd); err != nil {
-					fmt.Printf("error executing command - %v\n", err)
-				}
-			}
-		}
-	}()
-
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-
-	select {
-	case sig := <-quit:
-		fmt.Println()
-		fmt.Println()
-		log.Printf("Shutdown signal received (%v), initiating graceful shutdown...\n", sig)
-		cancel()
-	case <-userExit:
-		fmt.Println()
-		fmt.Println()
-		log.Println("Exit command received, initiating graceful shutdown...")
-		cancel()
+	command.InitJobControl()
+	for name, value := range config.GetConfig().Aliases {
+		if err := sh.SetAlias(name, value); err != nil {
+			fmt.Fprintf(os.Stderr, "traSH: .trashrc: %v\n", err)
+		}
+	}
+	command.CatchSignals(io.RestoreTerminal)
+	io.DirStack = sh.DirStack
+	io.Executables = sh.Executables
+
+	// exitStatus is what traSH exits with, as given to exit
+	exitStatus, exiting := 0, false
+	if rc := config.StartupScript(); rc != "" {
+		status, err := sh.SourceFile(rc, nil)
+		switch {
+		case errors.Is(err, command.ErrExit):
+			exitStatus, exiting = status, true
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "traSH: %v\n", err)
+		}
+	}
+
+	for !exiting {
+		command.NotifyJobs()
+		list, err := readList(sh)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "traSH: %v\n", err)
+			continue
+		}
+
+		if status, err := sh.RunList(list); errors.Is(err, command.ErrExit) {
+			exitStatus = status
+			break
+		}
 	}
 
+	exitStatus = sh.RunExitTrap(exitStatus)
+	fmt.Println()
+	fmt.Println()
+	log.Println("Exit command received, initiating graceful shutdown...")
 	log.Println("traSH has been killed (rightfully so)... Thanks for visiting :)")
+	return exitStatus
+}
+
+// readList reads a line and parses it, asking for more lines for as long as
+// the input so far stops in the middle of a command. Ctrl-C drops what was
+// read so far.
+func readList(sh *command.Shell) (*parser.List, error) {
+	src := io.ReadUserInput(io.BuildPrompt(sh.Status().Code))
+	for {
+		list, err := sh.Parse(src)
+		if !errors.Is(err, parser.ErrIncomplete) {
+			return list, err
+		}
+
+		line, err := io.ReadLine(">")
+		switch err {
+		case io.ErrInterrupted:
+			return &parser.List{}, nil
+		case io.ErrEOF:
+			return sh.Parse(src)
+		}
+		src += "\n" + line
+	}
 }
@@ -15,7 +15,9 @@ type Config struct {
 	Prompt       string
 	PromptColor  string
 	PromptSymbol string
-	openAIKey    string
+	// Aliases come from lines like `alias ll = ls -la`
+	Aliases   map[string]string
+	openAIKey string
 }
 
 var conf *Config
@@ -45,11 +47,29 @@ func loadConfig() *Config {
 		Prompt:       utils.Coalesce(trashRC["prompt"], defaultConfig.Prompt),
 		PromptColor:  utils.Coalesce(trashRC["color"], defaultConfig.PromptColor),
 		PromptSymbol: utils.Coalesce(trashRC["symbol"], defaultConfig.PromptSymbol),
+		Aliases:      parseAliases(trashRC),
 		openAIKey:    utils.Coalesce(trashRC["openai_key"], defaultConfig.openAIKey),
 	}
 
 }
 
+// parseAliases picks the alias entries out of a parsed .trashrc. The value
+// may be quoted, which keeps any blank at its end.
+func parseAliases(trashRC map[string]string) map[string]string {
+	aliases := make(map[string]string)
+	for key, value := range trashRC {
+		name, ok := strings.CutPrefix(key, "alias ")
+		if !ok {
+			continue
+		}
+		if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		aliases[strings.TrimSpace(name)] = value
+	}
+	return aliases
+}
+
 func GetConfig() *Config {
 	once.Do(func() {
 		conf = loadConfig()
@@ -57,6 +77,20 @@ func GetConfig() *Config {
 	return conf
 }
 
+// StartupScript returns the path of ~/.trashrc.sh, shell code that an
+// interactive shell runs before its first prompt, or "" when there is none
+func StartupScript() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	path := filepath.Join(home, ".trashrc.sh")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
 func (c *Config) GetAPIKey() string {
 	return c.openAIKey
 }
@@ -81,7 +115,9 @@ func ParseTrashRC(filepath string) map[string]string {
 			continue
 		}
 
-		pair := strings.SplitN(line, "=", 3)
+		// Only the first = separates, so that values like
+		// `alias grep = grep --color=auto` work
+		pair := strings.SplitN(line, "=", 2)
 		if len(pair) == 2 {
 			trashRC[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
 		} else {
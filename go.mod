@@ -3,6 +3,6 @@ module github.com/mush1e/traSH
 go 1.23.5
 
 require (
-	golang.org/x/sys v0.33.0 // indirect
-	golang.org/x/term v0.32.0 // indirect
+	golang.org/x/sys v0.33.0
+	golang.org/x/term v0.32.0
 )
@@ -79,7 +79,7 @@ func HandleAI(cmd *Command) error {
 	if err != nil {
 		return fmt.Errorf("AI error: %v", err)
 	}
-	fmt.Println(resp)
+	fmt.Fprintln(cmd.out(), resp)
 	return nil
 }
 
@@ -95,6 +95,6 @@ func HandleExplain(cmd *Command) error {
 	if err != nil {
 		return fmt.Errorf("AI error: %v", err)
 	}
-	fmt.Println(resp)
+	fmt.Fprintln(cmd.out(), resp)
 	return nil
 }
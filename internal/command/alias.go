@@ -0,0 +1,107 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mush1e/traSH/internal/parser"
+)
+
+// Parse parses src with the shell's aliases expanded
+func (sh *Shell) Parse(src string) (*parser.List, error) {
+	return parser.ParseWithAliases(src, sh.aliases)
+}
+
+// parseAt parses src, which starts at the given line of a script
+func (sh *Shell) parseAt(src string, line int) (*parser.List, error) {
+	return parser.ParseAt(src, line, sh.aliases)
+}
+
+// SetAlias defines an alias, as the alias builtin does
+func (sh *Shell) SetAlias(name, value string) error {
+	if !isAliasName(name) {
+		return fmt.Errorf("`%s': invalid alias name", name)
+	}
+	sh.aliases[name] = value
+	return nil
+}
+
+// isAliasName reports whether name can be used for an alias, which rules
+// out anything that would be quoted, expanded or split
+func isAliasName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\n'\"\\`$=/|&;<>()")
+}
+
+// HandleAlias defines aliases given as name=value, prints those given by
+// name, or lists them all
+func HandleAlias(cmd *Command) error {
+	sh := cmd.sh
+	args := cmd.args
+	if len(args) > 0 && args[0] == "-p" {
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		names := make([]string, 0, len(sh.aliases))
+		for name := range sh.aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(cmd.out(), "alias %s=%s\n", name, shellQuote(sh.aliases[name]))
+		}
+		return nil
+	}
+
+	failed := false
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			if value, ok := sh.aliases[name]; ok {
+				fmt.Fprintf(cmd.out(), "alias %s=%s\n", name, shellQuote(value))
+			} else {
+				fmt.Fprintf(cmd.errOut(), "traSH: alias: %s: not found\n", name)
+				failed = true
+			}
+			continue
+		}
+		if err := sh.SetAlias(name, value); err != nil {
+			fmt.Fprintf(cmd.errOut(), "traSH: alias: %v\n", err)
+			failed = true
+		}
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// HandleUnalias removes the named aliases, or all of them with -a
+func HandleUnalias(cmd *Command) error {
+	sh := cmd.sh
+	if len(cmd.args) == 0 {
+		return errors.New("usage: unalias [-a] name [name ...]")
+	}
+	if cmd.args[0] == "-a" {
+		clear(sh.aliases)
+		return nil
+	}
+
+	failed := false
+	for _, name := range cmd.args {
+		if _, ok := sh.aliases[name]; !ok {
+			fmt.Fprintf(cmd.errOut(), "traSH: unalias: %s: not found\n", name)
+			failed = true
+			continue
+		}
+		delete(sh.aliases, name)
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
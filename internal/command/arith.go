@@ -0,0 +1,667 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mush1e/traSH/internal/parser"
+)
+
+// maxArithDepth limits how deeply variables may refer to other variables
+// holding expressions, as in a=b b=a
+const maxArithDepth = 1024
+
+var (
+	errDivZero  = errors.New("division by zero")
+	errOverflow = errors.New("integer overflow")
+)
+
+// arithOps lists the operators of arithmetic expressions, longest first
+var arithOps = []string{
+	"<<=", ">>=",
+	"**", "<<", ">>", "<=", ">=", "==", "!=", "&&", "||", "++", "--",
+	"+=", "-=", "*=", "/=", "%=", "&=", "^=", "|=",
+	"+", "-", "*", "/", "%", "<", ">", "&", "|", "^", "!", "~", "?", ":", "=", ",", "(", ")", "[", "]",
+}
+
+// arithTok is a token of an arithmetic expression
+type arithTok struct {
+	kind byte // 'n' number, 'v' variable name, 'o' operator
+	val  string
+}
+
+// operand is the value of a subexpression, along with the variable it came
+// from when it can be assigned to
+type operand struct {
+	val   int64
+	name  string // variable name, empty unless an lvalue
+	index int64  // element of the array name refers to
+	elem  bool   // set when index applies
+}
+
+// arith evaluates one arithmetic expression. Operands that are not
+// evaluated, like the right side of a && whose left side is 0, are still
+// parsed but with skip set: they neither assign nor fail.
+type arith struct {
+	sh    *Shell
+	toks  []arithTok
+	i     int
+	skip  int
+	depth int
+}
+
+// evalArith evaluates an expression that has already been expanded, as
+// found in $((...)), let and ((...))
+func (sh *Shell) evalArith(expr string) (int64, error) {
+	n, err := sh.evalArithDepth(expr, 0)
+	if err != nil {
+		return 0, fmt.Errorf("traSH: %s: %v", strings.TrimSpace(expr), err)
+	}
+	return n, nil
+}
+
+func (sh *Shell) evalArithDepth(expr string, depth int) (int64, error) {
+	if depth > maxArithDepth {
+		return 0, errors.New("expression recursion level exceeded")
+	}
+	toks, err := lexArith(expr)
+	if err != nil {
+		return 0, err
+	}
+	if len(toks) == 0 {
+		return 0, nil
+	}
+
+	a := &arith{sh: sh, toks: toks, depth: depth}
+	v, err := a.comma()
+	if err != nil {
+		return 0, err
+	}
+	if a.i < len(a.toks) {
+		return 0, fmt.Errorf("syntax error in expression (error token is %q)", a.rest())
+	}
+	return v.val, nil
+}
+
+func lexArith(expr string) ([]arithTok, error) {
+	var toks []arithTok
+	rs := []rune(expr)
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case unicode.IsDigit(c):
+			// Numbers may be written as 0x1f, 017 or base#digits, whose
+			// digits include letters, @ and _
+			j := i
+			for j < len(rs) && (isNameRune(rs[j], false) || rs[j] == '#' || rs[j] == '@') {
+				j++
+			}
+			toks = append(toks, arithTok{'n', string(rs[i:j])})
+			i = j
+
+		case isNameRune(c, true):
+			j := i
+			for j < len(rs) && isNameRune(rs[j], false) {
+				j++
+			}
+			toks = append(toks, arithTok{'v', string(rs[i:j])})
+			i = j
+
+		default:
+			op := ""
+			for _, o := range arithOps {
+				if strings.HasPrefix(string(rs[i:min(i+3, len(rs))]), o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("syntax error: invalid arithmetic operator (error token is %q)", string(rs[i:]))
+			}
+			toks = append(toks, arithTok{'o', op})
+			i += len(op)
+		}
+	}
+	return toks, nil
+}
+
+// parseNumber reads an integer constant in any of the forms lexArith
+// accepts
+func parseNumber(s string) (int64, error) {
+	base := 10
+	digits := s
+	switch {
+	case strings.Contains(s, "#"):
+		b, d, _ := strings.Cut(s, "#")
+		n, err := strconv.Atoi(b)
+		if err != nil || n < 2 || n > 64 {
+			return 0, fmt.Errorf("invalid arithmetic base (error token is %q)", s)
+		}
+		base, digits = n, d
+	case strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X"):
+		base, digits = 16, s[2:]
+	case len(s) > 1 && s[0] == '0':
+		base, digits = 8, s[1:]
+	}
+	if digits == "" {
+		return 0, fmt.Errorf("invalid number (error token is %q)", s)
+	}
+
+	var n int64
+	for _, r := range digits {
+		d := digitValue(r, base)
+		if d < 0 || d >= base {
+			return 0, fmt.Errorf("value too great for base (error token is %q)", s)
+		}
+		if n > (math.MaxInt64-int64(d))/int64(base) {
+			return 0, errOverflow
+		}
+		n = n*int64(base) + int64(d)
+	}
+	return n, nil
+}
+
+// digitValue returns the value of r as a digit: 0-9, then a-z, A-Z, @ and _.
+// Up to base 36 letters are not case sensitive.
+func digitValue(r rune, base int) int {
+	switch {
+	case r >= '0' && r <= '9':
+		return int(r - '0')
+	case r >= 'a' && r <= 'z':
+		return int(r-'a') + 10
+	case r >= 'A' && r <= 'Z':
+		if base <= 36 {
+			return int(r-'A') + 10
+		}
+		return int(r-'A') + 36
+	case r == '@':
+		return 62
+	case r == '_':
+		return 63
+	}
+	return -1
+}
+
+func (a *arith) rest() string {
+	var parts []string
+	for _, t := range a.toks[a.i:] {
+		parts = append(parts, t.val)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (a *arith) peekOp(ops ...string) string {
+	if a.i >= len(a.toks) || a.toks[a.i].kind != 'o' {
+		return ""
+	}
+	for _, op := range ops {
+		if a.toks[a.i].val == op {
+			return op
+		}
+	}
+	return ""
+}
+
+func (a *arith) expect(op string) error {
+	if a.peekOp(op) == "" {
+		if a.i >= len(a.toks) {
+			return fmt.Errorf("syntax error: `%s' expected", op)
+		}
+		return fmt.Errorf("syntax error in expression (error token is %q)", a.rest())
+	}
+	a.i++
+	return nil
+}
+
+// value returns the current value of a variable, itself evaluated as an
+// expression. Unset and empty variables count as 0.
+func (a *arith) value(o operand) (int64, error) {
+	var s string
+	if o.elem {
+		values, _ := a.sh.vars.GetArray(o.name)
+		if o.index >= 0 && o.index < int64(len(values)) {
+			s = values[o.index]
+		}
+	} else {
+		s, _ = a.sh.lookup(o.name)
+	}
+	if s = strings.TrimSpace(s); s == "" || a.skip > 0 {
+		return 0, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	return a.sh.evalArithDepth(s, a.depth+1)
+}
+
+// assign stores n in the variable o refers to
+func (a *arith) assign(o operand, n int64) {
+	if a.skip > 0 {
+		return
+	}
+	s := strconv.FormatInt(n, 10)
+	if !o.elem {
+		a.sh.vars.Set(o.name, s)
+		return
+	}
+	values, _ := a.sh.vars.GetArray(o.name)
+	for int64(len(values)) <= o.index {
+		values = append(values, "")
+	}
+	values[o.index] = s
+	a.sh.vars.SetArray(o.name, values)
+}
+
+// comma parses expr, expr, ... whose value is that of the last one
+func (a *arith) comma() (operand, error) {
+	v, err := a.assignment()
+	for err == nil && a.peekOp(",") != "" {
+		a.i++
+		v, err = a.assignment()
+	}
+	return v, err
+}
+
+func (a *arith) assignment() (operand, error) {
+	lhs, err := a.ternary()
+	if err != nil {
+		return lhs, err
+	}
+	op := a.peekOp("=", "+=", "-=", "*=", "/=", "%=", "<<=", ">>=", "&=", "^=", "|=")
+	if op == "" {
+		return lhs, nil
+	}
+	if lhs.name == "" {
+		return lhs, fmt.Errorf("attempted assignment to non-variable (error token is %q)", a.rest())
+	}
+	a.i++
+
+	rhs, err := a.assignment()
+	if err != nil {
+		return rhs, err
+	}
+	n := rhs.val
+	if op != "=" {
+		if n, err = a.binary(strings.TrimSuffix(op, "="), lhs.val, rhs.val); err != nil {
+			return rhs, err
+		}
+	}
+	a.assign(lhs, n)
+	return operand{val: n}, nil
+}
+
+func (a *arith) ternary() (operand, error) {
+	cond, err := a.logical(0)
+	if err != nil || a.peekOp("?") == "" {
+		return cond, err
+	}
+	a.i++
+
+	if cond.val == 0 {
+		a.skip++
+	}
+	yes, err := a.comma()
+	if cond.val == 0 {
+		a.skip--
+	}
+	if err != nil {
+		return yes, err
+	}
+	if err := a.expect(":"); err != nil {
+		return yes, err
+	}
+
+	if cond.val != 0 {
+		a.skip++
+	}
+	no, err := a.assignment()
+	if cond.val != 0 {
+		a.skip--
+	}
+	if err != nil {
+		return no, err
+	}
+
+	if cond.val != 0 {
+		return operand{val: yes.val}, nil
+	}
+	return operand{val: no.val}, nil
+}
+
+// binaryLevels lists the binary operators from lowest to highest precedence
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// logical parses the binary operators of binaryLevels[level] and above,
+// all of which associate to the left
+func (a *arith) logical(level int) (operand, error) {
+	if level == len(binaryLevels) {
+		return a.power()
+	}
+
+	lhs, err := a.logical(level + 1)
+	for err == nil {
+		op := a.peekOp(binaryLevels[level]...)
+		if op == "" {
+			break
+		}
+		a.i++
+
+		// && and || leave their right side alone once the result is known
+		short := (op == "&&" && lhs.val == 0) || (op == "||" && lhs.val != 0)
+		if short {
+			a.skip++
+		}
+		var rhs operand
+		rhs, err = a.logical(level + 1)
+		if short {
+			a.skip--
+		}
+		if err != nil {
+			break
+		}
+
+		var n int64
+		n, err = a.binary(op, lhs.val, rhs.val)
+		lhs = operand{val: n}
+	}
+	return lhs, err
+}
+
+// power parses **, which binds tighter than the other binary operators and
+// associates to the right
+func (a *arith) power() (operand, error) {
+	base, err := a.unary()
+	if err != nil || a.peekOp("**") == "" {
+		return base, err
+	}
+	a.i++
+	exp, err := a.power()
+	if err != nil {
+		return exp, err
+	}
+	n, err := a.binary("**", base.val, exp.val)
+	return operand{val: n}, err
+}
+
+func (a *arith) unary() (operand, error) {
+	switch op := a.peekOp("+", "-", "!", "~", "++", "--"); op {
+	case "":
+		return a.postfix()
+
+	case "++", "--":
+		a.i++
+		o, err := a.postfix()
+		if err != nil {
+			return o, err
+		}
+		if o.name == "" {
+			return o, fmt.Errorf("syntax error: operand expected (error token is %q)", op)
+		}
+		n, err := a.binary(op[:1], o.val, 1)
+		if err != nil {
+			return o, err
+		}
+		a.assign(o, n)
+		return operand{val: n}, nil
+
+	default:
+		a.i++
+		o, err := a.unary()
+		if err != nil {
+			return o, err
+		}
+		switch op {
+		case "-":
+			if o.val == math.MinInt64 && a.skip == 0 {
+				return o, errOverflow
+			}
+			o.val = -o.val
+		case "!":
+			o.val = boolInt(o.val == 0)
+		case "~":
+			o.val = ^o.val
+		}
+		return operand{val: o.val}, nil
+	}
+}
+
+func (a *arith) postfix() (operand, error) {
+	o, err := a.primary()
+	if err != nil || o.name == "" {
+		return o, err
+	}
+	if op := a.peekOp("++", "--"); op != "" {
+		a.i++
+		n, err := a.binary(op[:1], o.val, 1)
+		if err != nil {
+			return o, err
+		}
+		a.assign(o, n)
+		return operand{val: o.val}, nil
+	}
+	return o, nil
+}
+
+func (a *arith) primary() (operand, error) {
+	if a.i >= len(a.toks) {
+		return operand{}, errors.New("syntax error: operand expected")
+	}
+	t := a.toks[a.i]
+	a.i++
+
+	switch {
+	case t.kind == 'n':
+		n, err := parseNumber(t.val)
+		return operand{val: n}, err
+
+	case t.kind == 'v':
+		o := operand{name: t.val}
+		if a.peekOp("[") != "" {
+			a.i++
+			idx, err := a.comma()
+			if err != nil {
+				return o, err
+			}
+			if err := a.expect("]"); err != nil {
+				return o, err
+			}
+			if idx.val < 0 && a.skip == 0 {
+				return o, fmt.Errorf("%s[%d]: bad array subscript", t.val, idx.val)
+			}
+			o.index, o.elem = idx.val, true
+		}
+		var err error
+		o.val, err = a.value(o)
+		if err != nil && a.peekOp("=") != "" {
+			// Plain assignment never needs the old value
+			err = nil
+		}
+		return o, err
+
+	case t.val == "(":
+		o, err := a.comma()
+		if err != nil {
+			return o, err
+		}
+		return operand{val: o.val}, a.expect(")")
+	}
+
+	a.i--
+	return operand{}, fmt.Errorf("syntax error: operand expected (error token is %q)", a.rest())
+}
+
+// binary applies a binary operator, failing on division by zero and on
+// results that do not fit in 64 bits
+func (a *arith) binary(op string, x, y int64) (int64, error) {
+	switch op {
+	case "+":
+		r := x + y
+		if (x > 0 && y > 0 && r < 0) || (x < 0 && y < 0 && r >= 0) {
+			return a.overflow(r)
+		}
+		return r, nil
+	case "-":
+		r := x - y
+		if (x >= 0 && y < 0 && r < 0) || (x < 0 && y > 0 && r >= 0) {
+			return a.overflow(r)
+		}
+		return r, nil
+	case "*":
+		r := x * y
+		if x != 0 && (r/x != y || (x == -1 && y == math.MinInt64)) {
+			return a.overflow(r)
+		}
+		return r, nil
+	case "/", "%":
+		if y == 0 {
+			if a.skip > 0 {
+				return 0, nil
+			}
+			return 0, errDivZero
+		}
+		if x == math.MinInt64 && y == -1 {
+			if op == "%" {
+				return 0, nil
+			}
+			return a.overflow(x)
+		}
+		if op == "/" {
+			return x / y, nil
+		}
+		return x % y, nil
+	case "**":
+		if y < 0 {
+			if a.skip > 0 {
+				return 0, nil
+			}
+			return 0, errors.New("exponent less than 0")
+		}
+		// Square and multiply; squaring only fails when the result
+		// would not fit either
+		r, b := int64(1), x
+		for {
+			var err error
+			if y&1 == 1 {
+				if r, err = a.binary("*", r, b); err != nil {
+					return 0, err
+				}
+			}
+			if y >>= 1; y == 0 {
+				return r, nil
+			}
+			if b, err = a.binary("*", b, b); err != nil {
+				return 0, err
+			}
+		}
+	case "<<":
+		return x << (uint64(y) & 63), nil
+	case ">>":
+		return x >> (uint64(y) & 63), nil
+	case "<":
+		return boolInt(x < y), nil
+	case "<=":
+		return boolInt(x <= y), nil
+	case ">":
+		return boolInt(x > y), nil
+	case ">=":
+		return boolInt(x >= y), nil
+	case "==":
+		return boolInt(x == y), nil
+	case "!=":
+		return boolInt(x != y), nil
+	case "&":
+		return x & y, nil
+	case "|":
+		return x | y, nil
+	case "^":
+		return x ^ y, nil
+	case "&&":
+		return boolInt(x != 0 && y != 0), nil
+	case "||":
+		return boolInt(x != 0 || y != 0), nil
+	}
+	return 0, fmt.Errorf("syntax error: unknown operator %q", op)
+}
+
+func (a *arith) overflow(r int64) (int64, error) {
+	if a.skip > 0 {
+		return r, nil
+	}
+	return 0, errOverflow
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// expandArith expands the text of an arithmetic expression: parameters,
+// command substitutions and quotes, but no tildes, splitting or globbing
+func (sh *Shell) expandArith(raw string) (string, error) {
+	x := &expander{sh: sh, noSplit: true, arith: true}
+	if err := x.word(raw); err != nil {
+		return "", err
+	}
+	return strings.Join(x.fields, " "), nil
+}
+
+// arithmetic expands and evaluates raw, as $((raw)) does
+func (sh *Shell) arithmetic(raw string) (int64, error) {
+	expr, err := sh.expandArith(raw)
+	if err != nil {
+		return 0, err
+	}
+	return sh.evalArith(expr)
+}
+
+// HandleLet evaluates each argument as an arithmetic expression. It
+// succeeds when the last one is not 0.
+func HandleLet(cmd *Command) error {
+	if len(cmd.args) == 0 {
+		return errors.New("expression expected")
+	}
+	var n int64
+	for _, arg := range cmd.args {
+		var err error
+		if n, err = cmd.sh.evalArith(arg); err != nil {
+			return err
+		}
+	}
+	if n == 0 {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// runArith runs ((expr)), which succeeds when expr is not 0
+func (sh *Shell) runArith(c *parser.ArithCommand) (int, error) {
+	n, err := sh.arithmetic(c.Expr)
+	if err != nil {
+		sh.report(err)
+		return 1, nil
+	}
+	if n == 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,187 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/mush1e/traSH/internal/parser"
+)
+
+// braceExpand performs brace expansion on a raw word, the first of the
+// expansions: a{b,c}d becomes abd acd, and {1..5} or {a..e} a sequence.
+// Braces inside quotes or parameter expansions are left alone, as are
+// braces that enclose neither a comma nor a valid sequence. The results are
+// still raw words, ready for the remaining expansions.
+func braceExpand(raw string) []string {
+	rs := []rune(raw)
+	for i := 0; i < len(rs); i++ {
+		switch rs[i] {
+		case '\\', '\'', '"', '`', '$':
+			i = parser.SkipQuoted(rs, i) - 1
+
+		case '{':
+			end, items, ok := braceItems(rs, i)
+			if !ok {
+				continue
+			}
+			prefix, suffix := string(rs[:i]), string(rs[end+1:])
+			var words []string
+			for _, item := range items {
+				words = append(words, braceExpand(prefix+item+suffix)...)
+			}
+			return words
+		}
+	}
+	return []string{raw}
+}
+
+// braceItems looks at the brace at rs[open]. If it starts a brace
+// expression it returns the index of the closing brace and what the
+// expression stands for.
+func braceItems(rs []rune, open int) (int, []string, bool) {
+	depth := 0
+	start := open + 1
+	var items []string
+	for i := open + 1; i < len(rs); i++ {
+		switch rs[i] {
+		case '\\', '\'', '"', '`', '$':
+			i = parser.SkipQuoted(rs, i) - 1
+		case '{':
+			depth++
+		case ',':
+			if depth == 0 {
+				items = append(items, string(rs[start:i]))
+				start = i + 1
+			}
+		case '}':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			if items != nil {
+				return i, append(items, string(rs[start:i])), true
+			}
+			seq, ok := braceSequence(string(rs[open+1 : i]))
+			return i, seq, ok
+		}
+	}
+	return 0, nil, false
+}
+
+// maxBraceSequence is the longest sequence {x..y} expands to. Longer ones
+// stay as they are rather than eating all memory.
+const maxBraceSequence = 1 << 20
+
+// braceSequence expands the inside of {x..y} or {x..y..step}, where x and
+// y are both integers or both single letters
+func braceSequence(body string) ([]string, bool) {
+	parts := strings.Split(body, "..")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil, false
+	}
+
+	var step uint64 = 1
+	if len(parts) == 3 {
+		n, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		// Negated as unsigned, the smallest int64 has a size too
+		step = uint64(n)
+		if n < 0 {
+			step = -step
+		}
+		if step == 0 {
+			step = 1
+		}
+	}
+
+	from, to := parts[0], parts[1]
+	x, errX := strconv.ParseInt(from, 10, 64)
+	y, errY := strconv.ParseInt(to, 10, 64)
+	if errX == nil && errY == nil {
+		n, ok := seqLen(x, y, step)
+		if !ok {
+			return nil, false
+		}
+		// A leading zero on either end pads every number to the same width
+		width := 0
+		if isZeroPadded(from) || isZeroPadded(to) {
+			width = max(len(from), len(to))
+		}
+		seq := make([]string, n)
+		for k := range seq {
+			seq[k] = zeroPad(seqAt(x, y, step, k), width)
+		}
+		return seq, true
+	}
+
+	rx, ry := []rune(from), []rune(to)
+	if len(rx) == 1 && len(ry) == 1 && isLetter(rx[0]) && isLetter(ry[0]) {
+		a, b := int64(rx[0]), int64(ry[0])
+		n, _ := seqLen(a, b, step)
+		seq := make([]string, n)
+		for k := range seq {
+			// Sequences like {Z..a} pass through punctuation, which must
+			// not be taken for quotes later
+			r := rune(seqAt(a, b, step, k))
+			if isLetter(r) {
+				seq[k] = string(r)
+			} else {
+				seq[k] = `\` + string(r)
+			}
+		}
+		return seq, true
+	}
+	return nil, false
+}
+
+// seqLen returns how many numbers a sequence from x to y in steps of step
+// has, or false when that is more than maxBraceSequence. The distance is
+// taken as unsigned, which holds even that between the ends of int64.
+func seqLen(x, y int64, step uint64) (int, bool) {
+	dist := uint64(y) - uint64(x)
+	if x > y {
+		dist = uint64(x) - uint64(y)
+	}
+	if dist/step >= maxBraceSequence {
+		return 0, false
+	}
+	return int(dist/step) + 1, true
+}
+
+// seqAt returns number k of the sequence going from x towards y in steps
+// of step, which lies between the two and so cannot overflow
+func seqAt(x, y int64, step uint64, k int) int64 {
+	offset := uint64(k) * step
+	if x > y {
+		return int64(uint64(x) - offset)
+	}
+	return int64(uint64(x) + offset)
+}
+
+func isZeroPadded(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	return len(s) > 1 && s[0] == '0'
+}
+
+// zeroPad formats n with at least width characters, counting any minus
+// sign, padding with zeros after the sign
+func zeroPad(n int64, width int) string {
+	s := strconv.FormatInt(n, 10)
+	digits, neg := strings.CutPrefix(s, "-")
+	if neg {
+		width--
+	}
+	if len(digits) < width {
+		digits = strings.Repeat("0", width-len(digits)) + digits
+	}
+	if neg {
+		return "-" + digits
+	}
+	return digits
+}
+
+func isLetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
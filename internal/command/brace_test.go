@@ -0,0 +1,41 @@
+package command
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBraceSequence(t *testing.T) {
+	tests := []struct {
+		word string
+		want []string
+	}{
+		{"{1..3}", []string{"1", "2", "3"}},
+		{"{3..1}", []string{"3", "2", "1"}},
+		{"{1..10..4}", []string{"1", "5", "9"}},
+		{"{10..1..-4}", []string{"10", "6", "2"}},
+		{"{1..3..0}", []string{"1", "2", "3"}},
+		{"{01..3}", []string{"01", "02", "03"}},
+		{"{-1..1}", []string{"-1", "0", "1"}},
+		{"{a..e..2}", []string{"a", "c", "e"}},
+
+		// The ends of int64, where stepping past the last number overflows
+		{"{9223372036854775806..9223372036854775807}", []string{"9223372036854775806", "9223372036854775807"}},
+		{"{-9223372036854775807..-9223372036854775808}", []string{"-9223372036854775807", "-9223372036854775808"}},
+		{"{1..9223372036854775807..4611686018427387904}", []string{"1", "4611686018427387905"}},
+		{"{1..5..-9223372036854775808}", []string{"1"}},
+		{"{-9223372036854775808..9223372036854775807..9223372036854775807}",
+			[]string{"-9223372036854775808", "-1", "9223372036854775806"}},
+		{"{a..z..9223372036854775807}", []string{"a"}},
+
+		// Too long a sequence, or numbers out of range, stay as they are
+		{"{1..10000000000}", []string{"{1..10000000000}"}},
+		{"{-9223372036854775808..9223372036854775807}", []string{"{-9223372036854775808..9223372036854775807}"}},
+		{"{1..9223372036854775808}", []string{"{1..9223372036854775808}"}},
+	}
+	for _, tt := range tests {
+		if got := braceExpand(tt.word); !slices.Equal(got, tt.want) {
+			t.Errorf("braceExpand(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
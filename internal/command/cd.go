@@ -1,21 +1,164 @@
 package command
 
-import "os"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
 
+// HandleCD changes the working directory and keeps PWD and OLDPWD up to
+// date. `cd -` goes back to OLDPWD. By default (-L) symlinks are kept in
+// PWD and .. removes the last component of the path as typed; with -P
+// every symlink is resolved first.
 func HandleCD(cmd *Command) error {
-	if len(cmd.args) == 0 || cmd.args[0] == "~" {
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			return err
+	sh := cmd.sh
+	physical := false
+
+	args := cmd.args
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		for _, opt := range args[0][1:] {
+			switch opt {
+			case 'L':
+				physical = false
+			case 'P':
+				physical = true
+			default:
+				return fmt.Errorf("-%c: invalid option\nusage: cd [-L|-P] [dir]", opt)
+			}
 		}
-		if err := os.Chdir(homeDir); err != nil {
-			return err
+		args = args[1:]
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	var dir string
+	printDir := false
+	switch {
+	case len(args) == 0:
+		home, ok := sh.vars.Get("HOME")
+		if !ok {
+			return fmt.Errorf("HOME not set")
 		}
-	} else {
-		if err := os.Chdir(cmd.args[0]); err != nil {
-			return err
+		dir = home
+	case args[0] == "-":
+		old, ok := sh.vars.Get("OLDPWD")
+		if !ok {
+			return fmt.Errorf("OLDPWD not set")
 		}
+		dir, printDir = old, true
+	default:
+		dir = args[0]
+	}
+	if dir == "" {
+		return nil
+	}
+
+	pwd, err := sh.cd(dir, physical)
+	if err != nil {
+		return err
 	}
 
+	if printDir {
+		fmt.Fprintln(cmd.out(), pwd)
+	}
 	return nil
 }
+
+// cd changes to dir the way the cd builtin does, updating PWD and OLDPWD,
+// and returns the new working directory
+func (sh *Shell) cd(dir string, physical bool) (string, error) {
+	oldPwd := sh.pwd()
+	target := dir
+	if !physical {
+		// Resolve .. against the logical path, not the physical one
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(oldPwd, target)
+		}
+		target = filepath.Clean(target)
+	}
+
+	pwd, err := sh.chdir(target, physical)
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			return "", errors.New(dir + ": " + pathErr.Err.Error())
+		}
+		return "", err
+	}
+
+	sh.vars.Set("OLDPWD", oldPwd)
+	sh.vars.Export("OLDPWD")
+	sh.vars.Set("PWD", pwd)
+	sh.vars.Export("PWD")
+	return pwd, nil
+}
+
+// chdir makes target the working directory and returns its new path, with
+// every symlink resolved when physical is set. The shell itself changes
+// the directory of the process; a subshell only its own.
+func (sh *Shell) chdir(target string, physical bool) (string, error) {
+	if sh.dir == "" {
+		if err := os.Chdir(target); err != nil {
+			return "", err
+		}
+		if physical {
+			// syscall.Getwd, unlike os.Getwd, never answers with $PWD
+			if wd, err := syscall.Getwd(); err == nil {
+				return wd, nil
+			}
+		}
+		return target, nil
+	}
+
+	path := sh.path(target)
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		return "", err
+	case !info.IsDir():
+		return "", &os.PathError{Op: "chdir", Path: target, Err: syscall.ENOTDIR}
+	}
+	if err := syscall.Access(path, 1); err != nil {
+		return "", &os.PathError{Op: "chdir", Path: target, Err: err}
+	}
+	if physical {
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			path = resolved
+		}
+	}
+	sh.dir = path
+	return path, nil
+}
+
+// pwd returns the logical working directory: PWD if it still names the
+// current directory, or the physical path otherwise
+func (sh *Shell) pwd() string {
+	wd := sh.dir
+	var err error
+	if wd == "" {
+		wd, err = os.Getwd()
+	}
+	if pwd, ok := sh.vars.Get("PWD"); ok && filepath.IsAbs(pwd) {
+		if sameFile(pwd, sh.path(".")) || err != nil {
+			return pwd
+		}
+	}
+	return wd
+}
+
+// sameFile reports whether a and b name the same file
+func sameFile(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	return err == nil && os.SameFile(ai, bi)
+}
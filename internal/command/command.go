@@ -1,23 +1,51 @@
 package command
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
-	"unicode"
+	"syscall"
 )
 
+// Command is a simple command ready to run: the command name, arguments
+// and options that handlers work with, produced by expanding a
+// parser.SimpleCommand.
 type Command struct {
 	command string
 	args    []string
 	opts    []rune
+	redirs  []redirect
+	files   []*os.File // open file descriptors, indexed by fd number
+
+	env []string // expanded assignments, NAME=value
+	sh  *Shell   // the shell the command runs in
+	// exe is the executable to run when it was looked up already, as
+	// command -p does
+	exe string
+	// dir is the working directory of a command without a shell, started
+	// on behalf of a builtin like env
+	dir string
 }
 
 func (c *Command) String() string {
 	return fmt.Sprintf("cmd : %s, args : %v, opts : %s", c.command, c.args, string(c.opts))
 }
 
+// text renders c back as a command line, e.g. for the jobs listing
+func (c *Command) text() string {
+	words := append([]string{}, c.env...)
+	if c.command != "" {
+		words = append(words, c.command)
+	}
+	words = append(words, c.args...)
+	for _, r := range c.redirs {
+		words = append(words, r.String())
+	}
+	return strings.Join(words, " ")
+}
+
 func (c *Command) GetCommand() string {
 	return c.command
 }
@@ -39,151 +67,252 @@ func (c *Command) HasOpt(opt rune) bool {
 	return false
 }
 
-func ParseCommand(command string) *Command {
-	// Handle empty commands
-	command = strings.TrimSpace(command)
-	if command == "" {
-		return &Command{}
-	}
+func (c *Command) in() *os.File {
+	return c.file(0, os.Stdin)
+}
 
-	commandList := parseCommandLine(command)
+func (c *Command) out() *os.File {
+	return c.file(1, os.Stdout)
+}
+
+func (c *Command) errOut() *os.File {
+	return c.file(2, os.Stderr)
+}
 
-	if len(commandList) == 0 {
-		return &Command{}
+// file returns the file open on fd, or def when fd was never set up.
+// A closed fd yields nil, which makes reads and writes fail cleanly.
+func (c *Command) file(fd int, def *os.File) *os.File {
+	if fd < len(c.files) {
+		return c.files[fd]
 	}
+	return def
+}
 
-	cmd := Command{
-		command: commandList[0],
-		args:    commandList[1:],
+// path resolves a file name against the working directory of the shell c
+// runs in
+func (c *Command) path(name string) string {
+	if c.sh == nil {
+		return name
 	}
+	return c.sh.path(name)
+}
+
+// setStdio replaces the first three file descriptors of c
+func (c *Command) setStdio(stdin, stdout, stderr *os.File) {
+	c.files = []*os.File{stdin, stdout, stderr}
+}
 
-	var filteredArgs []string
-	for _, arg := range cmd.args {
+// setWords fills in the command name, arguments and options from fully
+// expanded words
+func (c *Command) setWords(words []string) {
+	c.command, c.args, c.opts = "", nil, nil
+	if len(words) == 0 {
+		return
+	}
+	c.command = words[0]
+
+	for _, arg := range words[1:] {
 		if len(arg) > 0 && arg[0] == '-' {
 			// Handle both short (-abc) and long (--flag) options
 			if len(arg) > 1 && arg[1] == '-' {
 				// Long option like --verbose, store as single option
-				cmd.opts = append(cmd.opts, []rune(arg[2:])...)
+				c.opts = append(c.opts, []rune(arg[2:])...)
 			} else {
 				// Short options like -abc, each letter is an option
-				cmd.opts = append(cmd.opts, []rune(arg[1:])...)
+				c.opts = append(c.opts, []rune(arg[1:])...)
 			}
 		}
-		filteredArgs = append(filteredArgs, arg)
+		c.args = append(c.args, arg)
 	}
-	cmd.args = filteredArgs
-
-	return &cmd
 }
 
-// parseCommandLine splits a command line into arguments, respecting quotes
-func parseCommandLine(input string) []string {
-	var args []string
-	var current strings.Builder
-	var inQuotes bool
-	var quoteChar rune
-
-	input = strings.TrimSpace(input)
-	runes := []rune(input)
+// startExternal launches cmd as a child process of job without waiting for it
+func startExternal(cmd *Command, job *Job) (*os.Process, error) {
+	closeRedirects, err := applyRedirects(cmd)
+	if err != nil {
+		return nil, &redirectError{err}
+	}
+	// The child keeps its own copies of any file we opened
+	defer closeRedirects()
 
-	for i := 0; i < len(runes); i++ {
-		char := runes[i]
+	if cmd.command == "" {
+		return nil, nil
+	}
 
-		switch {
-		case char == '"' || char == '\'':
-			if !inQuotes {
-				// Start of quoted section
-				inQuotes = true
-				quoteChar = char
-			} else if char == quoteChar {
-				// End of quoted section
-				inQuotes = false
-				quoteChar = 0
-			} else {
-				// Different quote type inside quotes
-				current.WriteRune(char)
+	exe := cmd.exe
+	if exe == "" && !strings.Contains(cmd.command, "/") {
+		if cmd.sh != nil {
+			// Look it up in the shell's PATH, skipping the search when
+			// the command ran before
+			var ok bool
+			if exe, ok = cmd.sh.lookPath(cmd.command, true); !ok {
+				return nil, startError(cmd.command, exec.ErrNotFound)
 			}
+		} else if exe, err = exec.LookPath(cmd.command); err != nil {
+			return nil, startError(cmd.command, err)
+		}
+	}
+	if exe == "" {
+		exe = cmd.command
+	}
 
-		case char == '\\' && inQuotes && i+1 < len(runes):
-			// Handle escape sequences inside quotes
-			next := runes[i+1]
-			switch next {
-			case 'n':
-				current.WriteRune('\n')
-			case 't':
-				current.WriteRune('\t')
-			case 'r':
-				current.WriteRune('\r')
-			case '\\':
-				current.WriteRune('\\')
-			case '"', '\'':
-				current.WriteRune(next)
-			default:
-				// Unknown escape, keep both characters
-				current.WriteRune(char)
-				current.WriteRune(next)
-			}
-			i++ // Skip the next character as we've processed it
+	// A descriptor closed with <&- or >&- is nil, which leaves it closed
+	// in the child too
+	files := []*os.File{cmd.in(), cmd.out(), cmd.errOut()}
+	if len(cmd.files) > 3 {
+		files = append(files, cmd.files[3:]...)
+	}
+	attr := &os.ProcAttr{Files: files, Sys: job.sysProcAttr()}
+	// env adds to the shell's environment, or is the complete environment
+	// of commands started on behalf of a builtin like env
+	if cmd.sh != nil {
+		attr.Env = cmd.sh.vars.environWith(cmd.env)
+		attr.Dir = cmd.sh.dir
+	} else {
+		attr.Env = cmd.env
+		attr.Dir = cmd.dir
+	}
 
-		case unicode.IsSpace(char) && !inQuotes:
-			// Space outside quotes - end current argument
-			if current.Len() > 0 {
-				args = append(args, current.String())
-				current.Reset()
-			}
-			// Skip multiple spaces
-			for i+1 < len(runes) && unicode.IsSpace(runes[i+1]) {
-				i++
-			}
+	args := append([]string{cmd.command}, cmd.args...)
+	proc, err := os.StartProcess(exe, args, attr)
+	if errors.Is(err, syscall.ENOEXEC) {
+		// An executable text file without a #! line is a script for us
+		self, args := asScript(exe, cmd.args)
+		proc, err = os.StartProcess(self, args, attr)
+	}
+	if err != nil {
+		return nil, startError(cmd.command, err)
+	}
+	return proc, nil
+}
 
-		default:
-			// Regular character
-			current.WriteRune(char)
-		}
+// asScript returns the program and arguments that run the file exe, which
+// failed to execute, through traSH itself
+func asScript(exe string, args []string) (string, []string) {
+	self, err := os.Executable()
+	if err != nil {
+		self = os.Args[0]
 	}
+	return self, append([]string{self, exe}, args...)
+}
 
-	// Add the last argument if there is one
-	if current.Len() > 0 {
-		args = append(args, current.String())
+// HandleExternalCommand runs cmd as a foreground job and returns its exit
+// status. The error is only set when the process could not be started.
+func HandleExternalCommand(cmd *Command) (int, error) {
+	job := newJob(cmd.text(), true)
+	proc, err := startExternal(cmd, job)
+	if err != nil {
+		return statusOf(err), err
+	}
+	if proc == nil {
+		return 0, nil
 	}
 
-	return args
+	job.addProcess(proc)
+	return job.waitForeground(), nil
 }
 
-func HandleExternalCommand(cmd *Command) error {
-	if cmd.command == "" {
-		return nil
+// builtins maps built-in command names to their handlers. It is filled in
+// init so that handlers may look up other builtins.
+var builtins map[string]func(*Command) error
+
+func init() {
+	builtins = map[string]func(*Command) error{
+		"cd":       HandleCD,
+		"exit":     HandleExit,
+		"quit":     HandleExit,
+		"help":     HandleHelp,
+		"?":        HandleHelp,
+		"!ai":      HandleAI,
+		"!explain": HandleExplain,
+		"jobs":     HandleJobs,
+		"fg":       HandleFg,
+		"bg":       HandleBg,
+		"wait":     HandleWait,
+		"export":   HandleExport,
+		"unset":    HandleUnset,
+		"set":      HandleSet,
+		"env":      HandleEnv,
+		"shopt":    HandleShopt,
+		"break":    HandleBreak,
+		"continue": HandleContinue,
+		"return":   HandleReturn,
+		"local":    HandleLocal,
+		"declare":  HandleDeclare,
+		"alias":    HandleAlias,
+		"unalias":  HandleUnalias,
+		"let":      HandleLet,
+		"trap":     HandleTrap,
+		"dirs":     HandleDirs,
+		"pushd":    HandlePushd,
+		"popd":     HandlePopd,
+		"source":   HandleSource,
+		".":        HandleSource,
+		"hash":     HandleHash,
+		"type":     HandleType,
+		"which":    HandleWhich,
+		"command":  HandleCommandBuiltin,
+		"read":     HandleRead,
+		"test":     HandleTest,
+		"[":        HandleTest,
+		"shift":    HandleShift,
+		":":        HandleColon,
 	}
+}
 
-	c := exec.Command(cmd.command, cmd.args...)
-	c.Stdout = os.Stdout
-	c.Stderr = os.Stderr
-	c.Stdin = os.Stdin
+// HandleColon does nothing and succeeds. Its arguments are still expanded,
+// which makes : "${X:?message}" a check that X is set.
+func HandleColon(cmd *Command) error {
+	return nil
+}
 
-	if err := c.Run(); err != nil {
-		return fmt.Errorf("traSH: %s: %v", cmd.command, err)
+func isBuiltin(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
+
+// HandleCommand runs a single command, builtin or external, and returns its
+// exit status. A returned error has not been shown to the user yet; errors
+// raised by builtins are written to the builtin's own stderr instead, so that
+// `cd nowhere 2>/dev/null` stays quiet.
+func HandleCommand(cmd *Command) (int, error) {
+	if cmd.command == "" && len(cmd.redirs) == 0 && len(cmd.env) == 0 {
+		return 0, nil
 	}
-	return nil
+
+	if cmd.command == "" && cmd.sh != nil {
+		// A bare NAME=value sets a shell variable
+		for _, kv := range cmd.env {
+			name, value, _ := strings.Cut(kv, "=")
+			cmd.sh.vars.Set(name, value)
+		}
+		cmd.env = nil
+	}
+
+	if cmd.sh != nil {
+		// Functions come before builtins, so they can wrap them
+		if f, ok := cmd.sh.funcs[cmd.command]; ok {
+			return cmd.sh.callFunction(f, cmd)
+		}
+	}
+
+	return runCommand(cmd)
 }
 
-func HandleCommand(cmd *Command) error {
-	if cmd.command == "" {
-		return nil
-	}
-
-	switch cmd.command {
-	case "cd":
-		return HandleCD(cmd)
-	case "exit", "quit":
-		return nil
-	case "help", "?":
-		printHelp()
-		return nil
-	case "!ai":
-		return HandleAI(cmd)
-	case "!explain":
-		return HandleExplain(cmd)
-	default:
-		return HandleExternalCommand(cmd)
+// runCommand runs cmd as a builtin or an external command, whether or not
+// a function has the same name
+func runCommand(cmd *Command) (int, error) {
+	if handler, ok := builtins[cmd.command]; ok {
+		closeRedirects, err := applyRedirects(cmd)
+		if err != nil {
+			return 1, err
+		}
+		defer closeRedirects()
+		if len(cmd.env) > 0 && cmd.sh != nil {
+			// NAME=value in front of a builtin only lasts for that builtin
+			defer cmd.sh.vars.setTemporarily(cmd.env)()
+		}
+		return builtinStatus(cmd, handler(cmd))
 	}
+	return HandleExternalCommand(cmd)
 }
@@ -0,0 +1,251 @@
+package command
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"github.com/mush1e/traSH/internal/parser"
+)
+
+// runInShell runs a command other than a simple command: a compound command
+// or a function definition
+func (sh *Shell) runInShell(c parser.Command) (int, error) {
+	if f, ok := c.(*parser.FuncDecl); ok {
+		sh.defineFunction(f)
+		return 0, nil
+	}
+	return sh.runCompound(c.(parser.Compound))
+}
+
+// runCompound runs c right here in the shell, with the redirections written
+// after it in effect for everything inside it
+func (sh *Shell) runCompound(c parser.Compound) (int, error) {
+	restore, err := sh.redirectAll(c.Redirects())
+	if err != nil {
+		sh.report(err)
+		return 1, nil
+	}
+	defer restore()
+
+	switch c := c.(type) {
+	case *parser.IfClause:
+		return sh.runIf(c)
+	case *parser.WhileClause:
+		return sh.runWhile(c)
+	case *parser.ForClause:
+		return sh.runFor(c)
+	case *parser.CaseClause:
+		return sh.runCase(c)
+	case *parser.Group:
+		return sh.RunList(c.Body)
+	case *parser.Subshell:
+		return sh.runSubshell(c), nil
+	case *parser.ArithCommand:
+		return sh.runArith(c)
+	case *parser.CondCommand:
+		return sh.runCond(c)
+	}
+	return 0, nil
+}
+
+// redirectAll points the shell's own stdio at the targets of redirs until
+// the returned function is called
+func (sh *Shell) redirectAll(redirs []*parser.Redirect) (func(), error) {
+	if len(redirs) == 0 {
+		return func() {}, nil
+	}
+
+	cmd := &Command{sh: sh}
+	for _, r := range redirs {
+		target, err := sh.expandRedirectTarget(r)
+		if err != nil {
+			return nil, err
+		}
+		cmd.redirs = append(cmd.redirs, redirect{fd: r.Fd, op: r.Op, target: target})
+	}
+	cmd.setStdio(sh.stdin, sh.stdout, sh.stderr)
+	closeRedirects, err := applyRedirects(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	stdin, stdout, stderr := sh.stdin, sh.stdout, sh.stderr
+	sh.stdin, sh.stdout, sh.stderr = cmd.in(), cmd.out(), cmd.errOut()
+	return func() {
+		sh.stdin, sh.stdout, sh.stderr = stdin, stdout, stderr
+		closeRedirects()
+	}, nil
+}
+
+// runStage runs c as one stage of job, a pipeline or a background job. It
+// gets a subshell of its own, just like an external command would.
+func (sh *Shell) runStage(c parser.Command, stdin, stdout *os.File, job *Job) func() int {
+	sub := sh.subshell()
+	sub.async = true
+	if job.leader != nil {
+		sub.group = job
+	}
+	sub.stdin, sub.stdout = stdin, stdout
+	return func() int {
+		status, _ := sub.runInShell(c)
+		status = sub.RunExitTrap(status)
+		sh.closeUnlessStd(stdin)
+		sh.closeUnlessStd(stdout)
+		return status
+	}
+}
+
+// runSubshell runs the body of c in a copy of the shell, so that exit,
+// return and everything else it does only end or change the copy
+func (sh *Shell) runSubshell(c *parser.Subshell) int {
+	sub := sh.subshell()
+	status, _ := sub.RunList(c.Body)
+	if sub.interrupted() {
+		sh.last = sub.last
+	}
+	return sub.RunExitTrap(status)
+}
+
+// interrupted reports whether the last pipeline was killed by Ctrl-C, which
+// should stop a loop or list as much as the command that was running
+func (sh *Shell) interrupted() bool {
+	_, trapped := sh.traps["INT"]
+	return sh.last.Signal == syscall.SIGINT && !trapped
+}
+
+func (sh *Shell) runIf(c *parser.IfClause) (int, error) {
+	for _, b := range c.Branches {
+		status, err := sh.runCondition(b.Cond)
+		if err != nil {
+			return status, err
+		}
+		if status == 0 {
+			return sh.RunList(b.Body)
+		}
+	}
+	if c.Else != nil {
+		return sh.RunList(c.Else)
+	}
+	return 0, nil
+}
+
+// runCondition runs a list whose status decides what runs next, which
+// keeps a failure in it from counting as an error
+func (sh *Shell) runCondition(l *parser.List) (int, error) {
+	sh.condition++
+	defer func() { sh.condition-- }()
+	return sh.RunList(l)
+}
+
+// loopAction says how a loop goes on after running part of it
+type loopAction int
+
+const (
+	loopNext loopAction = iota
+	loopContinue
+	loopBreak
+)
+
+// runLoopPart runs the condition or body of a loop, handling any break or
+// continue meant for this loop. Errors that must unwind further are passed
+// on.
+func (sh *Shell) runLoopPart(l *parser.List) (int, loopAction, error) {
+	status, err := sh.RunList(l)
+
+	var lc *loopControl
+	switch {
+	case errors.As(err, &lc):
+		if lc.depth > 1 {
+			lc.depth--
+			return status, loopBreak, lc
+		}
+		if lc.cont {
+			return status, loopContinue, nil
+		}
+		return status, loopBreak, nil
+	case err != nil:
+		return status, loopBreak, err
+	case sh.interrupted():
+		return status, loopBreak, nil
+	}
+	return status, loopNext, nil
+}
+
+func (sh *Shell) runWhile(c *parser.WhileClause) (int, error) {
+	sh.loopDepth++
+	defer func() { sh.loopDepth-- }()
+
+	status := 0
+	for {
+		sh.condition++
+		cond, action, err := sh.runLoopPart(c.Cond)
+		sh.condition--
+		switch {
+		case action == loopBreak:
+			return cond, err
+		case action == loopContinue:
+			continue
+		case (cond == 0) == c.Until:
+			return status, nil
+		}
+
+		status, action, err = sh.runLoopPart(c.Body)
+		if action == loopBreak {
+			return status, err
+		}
+	}
+}
+
+func (sh *Shell) runFor(c *parser.ForClause) (int, error) {
+	values := sh.params
+	if c.In {
+		raw := make([]string, len(c.Words))
+		for i, w := range c.Words {
+			raw[i] = w.Raw
+		}
+		var err error
+		if values, err = sh.expandWords(raw); err != nil {
+			sh.report(err)
+			return 1, nil
+		}
+	}
+	values = append([]string(nil), values...)
+
+	sh.loopDepth++
+	defer func() { sh.loopDepth-- }()
+
+	status := 0
+	for _, v := range values {
+		sh.vars.Set(c.Name, v)
+		var action loopAction
+		var err error
+		status, action, err = sh.runLoopPart(c.Body)
+		if action == loopBreak {
+			return status, err
+		}
+	}
+	return status, nil
+}
+
+func (sh *Shell) runCase(c *parser.CaseClause) (int, error) {
+	word, err := sh.expandString(c.Word.Raw)
+	if err != nil {
+		sh.report(err)
+		return 1, nil
+	}
+
+	for _, item := range c.Items {
+		for _, p := range item.Patterns {
+			pat, err := sh.expandPattern(p.Raw)
+			if err != nil {
+				sh.report(err)
+				return 1, nil
+			}
+			if matchGlob([]rune(pat), []rune(word)) {
+				return sh.RunList(item.Body)
+			}
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,281 @@
+package command
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/mush1e/traSH/internal/parser"
+)
+
+// condExpr is a parsed [[ ]] expression. Its operands are kept as raw
+// words and only expanded when they are needed, so that && and || can
+// skip the side they don't look at.
+type condExpr struct {
+	op   string // && || ! or a test operator, "" for a lone word
+	args []string
+	x, y *condExpr
+}
+
+// runCond runs [[ expression ]]. It succeeds when the expression is true,
+// and gives status 2 when it cannot be parsed.
+func (sh *Shell) runCond(c *parser.CondCommand) (int, error) {
+	cp := &condParser{words: condWords([]rune(c.Expr))}
+	e, err := cp.or()
+	if err == nil && cp.pos < len(cp.words) {
+		err = cp.unexpected()
+	}
+	if err != nil {
+		sh.report(errors.New("traSH: " + err.Error()))
+		return 2, nil
+	}
+
+	cmd := &Command{sh: sh, command: "[["}
+	cmd.setStdio(sh.stdin, sh.stdout, sh.stderr)
+	ok, err := cmd.evalCond(e)
+	var te testError
+	switch {
+	case errors.As(err, &te):
+		sh.report(errors.New("traSH: [[: " + err.Error()))
+		return 2, nil
+	case err != nil:
+		sh.report(err)
+		return 1, nil
+	case !ok:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// evalCond evaluates e. Words are expanded without being split or
+// globbed, and the right side of == and != is a pattern while that of =~
+// is a regular expression.
+func (c *Command) evalCond(e *condExpr) (bool, error) {
+	sh := c.sh
+	switch e.op {
+	case "&&", "||":
+		ok, err := c.evalCond(e.x)
+		if err != nil || ok == (e.op == "||") {
+			return ok, err
+		}
+		return c.evalCond(e.y)
+	case "!":
+		ok, err := c.evalCond(e.x)
+		return !ok, err
+	case "-eq", "-ne", "-lt", "-le", "-gt", "-ge":
+		// Both sides are arithmetic expressions
+		x, err := sh.arithmetic(e.args[0])
+		if err != nil {
+			return false, err
+		}
+		y, err := sh.arithmetic(e.args[1])
+		if err != nil {
+			return false, err
+		}
+		return compareInts(e.op, x, y), nil
+	}
+
+	left, err := sh.expandString(e.args[0])
+	if err != nil {
+		return false, err
+	}
+	switch e.op {
+	case "":
+		return left != "", nil
+	case "=", "==", "!=":
+		pat, err := sh.expandPattern(e.args[1])
+		if err != nil {
+			return false, err
+		}
+		return matchGlob([]rune(pat), []rune(left)) == (e.op != "!="), nil
+	case "=~":
+		return sh.matchRegex(left, e.args[1])
+	}
+	if len(e.args) == 1 {
+		return c.unaryTest(e.op, left)
+	}
+	right, err := sh.expandString(e.args[1])
+	if err != nil {
+		return false, err
+	}
+	return c.binaryTest(e.op, left, right)
+}
+
+// matchRegex matches s against the extended regular expression in the raw
+// word re. BASH_REMATCH gets the matched text followed by what each group
+// matched.
+func (sh *Shell) matchRegex(s, raw string) (bool, error) {
+	expr, err := sh.expandRegex(raw)
+	if err != nil {
+		return false, err
+	}
+	re, err := regexp.CompilePOSIX(expr)
+	if err != nil {
+		return false, testError(expr + ": invalid regular expression")
+	}
+	m := re.FindStringSubmatch(s)
+	sh.vars.SetArray("BASH_REMATCH", m)
+	return m != nil, nil
+}
+
+// condParser parses the words of [[ ]] into a condExpr, with ! binding
+// tighter than && and && tighter than ||
+type condParser struct {
+	words []string
+	pos   int
+}
+
+func (p *condParser) peek() string {
+	if p.pos < len(p.words) {
+		return p.words[p.pos]
+	}
+	return ""
+}
+
+func (p *condParser) next() string {
+	w := p.peek()
+	p.pos++
+	return w
+}
+
+func (p *condParser) unexpected() error {
+	if p.pos >= len(p.words) {
+		return testError("unexpected end of conditional expression")
+	}
+	return testError("syntax error in conditional expression: unexpected token `" + p.peek() + "'")
+}
+
+// isOperand reports whether w can stand for a string, rather than being
+// one of the operators that join expressions
+func isOperand(w string) bool {
+	switch w {
+	case "", "&&", "||", "(", ")":
+		return false
+	}
+	return true
+}
+
+func (p *condParser) or() (*condExpr, error) {
+	x, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.pos++
+		var y *condExpr
+		y, err = p.and()
+		x = &condExpr{op: "||", x: x, y: y}
+	}
+	return x, err
+}
+
+func (p *condParser) and() (*condExpr, error) {
+	x, err := p.not()
+	for err == nil && p.peek() == "&&" {
+		p.pos++
+		var y *condExpr
+		y, err = p.not()
+		x = &condExpr{op: "&&", x: x, y: y}
+	}
+	return x, err
+}
+
+func (p *condParser) not() (*condExpr, error) {
+	if p.peek() == "!" {
+		p.pos++
+		x, err := p.not()
+		return &condExpr{op: "!", x: x}, err
+	}
+	return p.primary()
+}
+
+func (p *condParser) primary() (*condExpr, error) {
+	w := p.peek()
+	if w == "(" {
+		p.pos++
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, p.unexpected()
+		}
+		p.pos++
+		return x, nil
+	}
+	if !isOperand(w) {
+		return nil, p.unexpected()
+	}
+	p.pos++
+
+	if op := p.peek(); binaryTests[op] || op == "=~" {
+		p.pos++
+		// After =~ even ( or ) is a regular expression
+		if !isOperand(p.peek()) && (op != "=~" || p.peek() == "") {
+			return nil, p.unexpected()
+		}
+		return &condExpr{op: op, args: []string{w, p.next()}}, nil
+	}
+	if unaryTests[w] && isOperand(p.peek()) {
+		return &condExpr{op: w, args: []string{p.next()}}, nil
+	}
+	return &condExpr{args: []string{w}}, nil
+}
+
+// condWords splits the text between [[ and ]] into raw words. Unquoted
+// (, ), &&, || and the < and > comparisons are words of their own. The
+// word after =~ is a regular expression, so it runs up to the first blank
+// outside parentheses and can hold ( ) | < and > itself.
+func condWords(rs []rune) []string {
+	var words []string
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '\\' && i+1 < len(rs) && rs[i+1] == '\n':
+			i += 2
+		case len(words) > 0 && words[len(words)-1] == "=~":
+			end := condWord(rs, i, true)
+			// An unclosed ( runs to the end, blanks and all
+			words = append(words, strings.TrimRight(string(rs[i:end]), " \t\n"))
+			i = end
+		case (c == '&' || c == '|') && i+1 < len(rs) && rs[i+1] == c:
+			words = append(words, string(rs[i:i+2]))
+			i += 2
+		case strings.ContainsRune("()<>&|;", c):
+			words = append(words, string(c))
+			i++
+		default:
+			end := condWord(rs, i, false)
+			words = append(words, string(rs[i:end]))
+			i = end
+		}
+	}
+	return words
+}
+
+// condWord returns the index just past the word starting at rs[i]
+func condWord(rs []rune, i int, regex bool) int {
+	depth := 0
+	for i < len(rs) {
+		c := rs[i]
+		switch {
+		case strings.ContainsRune(`\'"$`+"`", c):
+			i = parser.SkipQuoted(rs, i)
+			continue
+		case c == ' ' || c == '\t' || c == '\n':
+			if depth == 0 {
+				return i
+			}
+		case regex && c == '(':
+			depth++
+		case regex && c == ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case !regex && strings.ContainsRune("()<>&|;", c):
+			return i
+		}
+		i++
+	}
+	return i
+}
@@ -0,0 +1,236 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dirList returns the directory stack as dirs shows it: the working
+// directory first, then the directories pushd saved, most recent first
+func (sh *Shell) dirList() []string {
+	return append([]string{sh.pwd()}, sh.dirStack...)
+}
+
+// DirStack returns the directories saved by pushd, most recent first
+func (sh *Shell) DirStack() []string {
+	return append([]string(nil), sh.dirStack...)
+}
+
+// stackIndex turns +N (counting from the left of dirs, starting at 0) or -N
+// (counting from the right) into an index into a list of n directories
+func stackIndex(arg string, n int) (int, bool) {
+	if len(arg) < 2 || (arg[0] != '+' && arg[0] != '-') || !isDigits(arg[1:]) {
+		return 0, false
+	}
+	i, err := strconv.Atoi(arg[1:])
+	if err != nil || i >= n {
+		return 0, false
+	}
+	if arg[0] == '-' {
+		i = n - 1 - i
+	}
+	return i, true
+}
+
+// isStackArg reports whether arg has the form +N or -N
+func isStackArg(arg string) bool {
+	return len(arg) > 1 && (arg[0] == '+' || arg[0] == '-') && isDigits(arg[1:])
+}
+
+// tildeShort abbreviates the home directory at the start of dir to ~
+func (sh *Shell) tildeShort(dir string) string {
+	home, ok := sh.vars.Get("HOME")
+	if !ok || home == "" || home == "/" {
+		return dir
+	}
+	if dir == home {
+		return "~"
+	}
+	if strings.HasPrefix(dir, home+"/") {
+		return "~" + dir[len(home):]
+	}
+	return dir
+}
+
+// printDirs writes the stack the way dirs does without options
+func (sh *Shell) printDirs(cmd *Command) {
+	list := sh.dirList()
+	for i, dir := range list {
+		list[i] = sh.tildeShort(dir)
+	}
+	fmt.Fprintln(cmd.out(), strings.Join(list, " "))
+}
+
+// HandleDirs shows the directory stack. -v numbers the entries, -p puts
+// one per line, -l leaves ~ unabbreviated and -c clears the stack; +N and
+// -N show a single entry.
+func HandleDirs(cmd *Command) error {
+	sh := cmd.sh
+	long, perLine, numbered := false, false, false
+	var pick string
+
+	for _, arg := range cmd.args {
+		switch {
+		case isStackArg(arg):
+			pick = arg
+		case len(arg) > 1 && arg[0] == '-':
+			for _, opt := range arg[1:] {
+				switch opt {
+				case 'c':
+					sh.dirStack = nil
+					return nil
+				case 'l':
+					long = true
+				case 'p':
+					perLine = true
+				case 'v':
+					perLine, numbered = true, true
+				default:
+					return fmt.Errorf("-%c: invalid option\nusage: dirs [-clpv] [+N] [-N]", opt)
+				}
+			}
+		default:
+			return fmt.Errorf("%s: invalid argument\nusage: dirs [-clpv] [+N] [-N]", arg)
+		}
+	}
+
+	list := sh.dirList()
+	if !long {
+		for i, dir := range list {
+			list[i] = sh.tildeShort(dir)
+		}
+	}
+
+	if pick != "" {
+		i, ok := stackIndex(pick, len(list))
+		if !ok {
+			return fmt.Errorf("%s: directory stack index out of range", pick)
+		}
+		fmt.Fprintln(cmd.out(), list[i])
+		return nil
+	}
+
+	switch {
+	case numbered:
+		for i, dir := range list {
+			fmt.Fprintf(cmd.out(), "%2d  %s\n", i, dir)
+		}
+	case perLine:
+		for _, dir := range list {
+			fmt.Fprintln(cmd.out(), dir)
+		}
+	default:
+		fmt.Fprintln(cmd.out(), strings.Join(list, " "))
+	}
+	return nil
+}
+
+// HandlePushd saves the working directory on the stack and changes to dir.
+// Without arguments it swaps the top two directories; +N and -N rotate the
+// stack so that entry N comes out on top. -n only changes the stack.
+func HandlePushd(cmd *Command) error {
+	sh := cmd.sh
+	noCD := false
+	var args []string
+	for _, arg := range cmd.args {
+		if arg == "-n" {
+			noCD = true
+			continue
+		}
+		args = append(args, arg)
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	list := sh.dirList()
+	switch {
+	case len(args) == 0:
+		if len(list) < 2 {
+			return fmt.Errorf("no other directory")
+		}
+		list[0], list[1] = list[1], list[0]
+
+	case isStackArg(args[0]):
+		if len(list) < 2 {
+			return fmt.Errorf("directory stack empty")
+		}
+		i, ok := stackIndex(args[0], len(list))
+		if !ok {
+			return fmt.Errorf("%s: directory stack index out of range", args[0])
+		}
+		list = append(list[i:], list[:i]...)
+
+	case noCD:
+		// The new directory goes right below the working directory
+		sh.dirStack = append([]string{args[0]}, sh.dirStack...)
+		sh.printDirs(cmd)
+		return nil
+
+	default:
+		if _, err := sh.cd(args[0], false); err != nil {
+			return err
+		}
+		sh.dirStack = list
+		sh.printDirs(cmd)
+		return nil
+	}
+
+	if !noCD {
+		if _, err := sh.cd(list[0], false); err != nil {
+			return err
+		}
+	}
+	sh.dirStack = list[1:]
+	sh.printDirs(cmd)
+	return nil
+}
+
+// HandlePopd removes the top directory from the stack and changes to the
+// one below it. +N and -N remove entry N instead; -n leaves the working
+// directory alone.
+func HandlePopd(cmd *Command) error {
+	sh := cmd.sh
+	noCD := false
+	var args []string
+	for _, arg := range cmd.args {
+		switch {
+		case arg == "-n":
+			noCD = true
+		case isStackArg(arg):
+			args = append(args, arg)
+		default:
+			return fmt.Errorf("%s: invalid argument\nusage: popd [-n] [+N | -N]", arg)
+		}
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("too many arguments")
+	}
+	if len(sh.dirStack) == 0 {
+		return fmt.Errorf("directory stack empty")
+	}
+
+	list := sh.dirList()
+	i := 0
+	if len(args) == 1 {
+		var ok bool
+		if i, ok = stackIndex(args[0], len(list)); !ok {
+			return fmt.Errorf("%s: directory stack index out of range", args[0])
+		}
+	}
+	if i == 0 && noCD {
+		// Without changing directory, popd drops the entry below the top
+		i = 1
+	}
+
+	if i == 0 {
+		if _, err := sh.cd(list[1], false); err != nil {
+			return err
+		}
+	}
+	list = append(list[:i], list[i+1:]...)
+	sh.dirStack = list[1:]
+	sh.printDirs(cmd)
+	return nil
+}
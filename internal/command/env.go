@@ -0,0 +1,72 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mush1e/traSH/internal/parser"
+)
+
+// HandleEnv prints the environment child processes get, or runs a command
+// in a modified environment: env [-i] [-u NAME] [NAME=value ...] [cmd [args]]
+func HandleEnv(cmd *Command) error {
+	env := cmd.sh.vars.environWith(cmd.env)
+	args := cmd.args
+
+	for len(args) > 0 {
+		arg := args[0]
+		switch {
+		case arg == "-i" || arg == "-":
+			env = []string{}
+		case arg == "-u":
+			if len(args) < 2 {
+				return fmt.Errorf("option requires an argument -- 'u'")
+			}
+			env = withoutVar(env, args[1])
+			args = args[1:]
+		case arg == "--":
+			args = args[1:]
+			goto run
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("%s: invalid option\nusage: env [-i] [-u name] [name=value ...] [command [arg ...]]", arg)
+		case parser.IsAssignment(arg):
+			name, _, _ := strings.Cut(arg, "=")
+			env = append(withoutVar(env, name), arg)
+		default:
+			goto run
+		}
+		args = args[1:]
+	}
+
+run:
+	if len(args) == 0 {
+		for _, kv := range env {
+			fmt.Fprintln(cmd.out(), kv)
+		}
+		return nil
+	}
+
+	// The child is not tied to the shell, so env is its whole environment,
+	// but it still starts in the directory of a subshell
+	child := &Command{files: cmd.files, env: env, dir: cmd.sh.dir}
+	child.setWords(args)
+	status, err := HandleExternalCommand(child)
+	if err != nil {
+		fmt.Fprintln(cmd.errOut(), err)
+	}
+	if status != 0 {
+		return ExitStatus(status)
+	}
+	return nil
+}
+
+// withoutVar returns env minus any entry for name
+func withoutVar(env []string, name string) []string {
+	kept := make([]string, 0, len(env))
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, name+"=") {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,758 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mush1e/traSH/internal/parser"
+)
+
+// field is one word being built up during expansion
+type field struct {
+	sb strings.Builder
+	// pat is the same word as a glob pattern, in which quoted
+	// metacharacters are escaped so that they only match themselves
+	pat strings.Builder
+	// re is the same word as a regular expression, quoted the same way
+	re strings.Builder
+	// glob is set once an unquoted *, ? or [ was added
+	glob bool
+	// quoted is set once any part of the field was quoted, so that it
+	// survives even when it ends up empty, as in ""
+	quoted bool
+}
+
+// add appends s to the field, quoted or not
+func (f *field) add(s string, quoted bool) {
+	f.sb.WriteString(s)
+	if quoted {
+		f.pat.WriteString(escapeGlob(s))
+		f.re.WriteString(regexp.QuoteMeta(s))
+		f.quoted = true
+		return
+	}
+	f.pat.WriteString(s)
+	f.re.WriteString(s)
+	if strings.ContainsAny(s, "*?[") {
+		f.glob = true
+	}
+}
+
+// expander turns raw words into fields. It follows the usual shell rules:
+// nothing is expanded inside single quotes, parameters and command
+// substitutions are expanded inside double quotes but their values are not
+// split, and unquoted expansions are split on IFS. Fields with unquoted
+// glob characters are then replaced by the paths they match.
+type expander struct {
+	sh      *Shell
+	noSplit bool // expand to a single string, as for assignments
+	// assignment also expands a tilde after = or :, as in PATH=~/bin:~/go/bin
+	assignment bool
+	// arith expands the text of an arithmetic expression, where a ~ is an
+	// operator
+	arith bool
+	// splitText splits unquoted literal text as well, as it is in the word
+	// of ${VAR:-word} and ${VAR:+word}
+	splitText bool
+	fields    []string
+	cur       *field
+	// softBreak is set right after a field was ended by IFS whitespace, so
+	// that a non-whitespace separator next to it doesn't add an empty field
+	softBreak bool
+	// err is a failed glob, reported once the word is done
+	err error
+}
+
+// expandWords expands raw words into the fields a command is run with.
+// Brace expansion comes first, so each of its results goes through the
+// rest on its own.
+func (sh *Shell) expandWords(words []string) ([]string, error) {
+	x := &expander{sh: sh}
+	for _, w := range words {
+		for _, b := range braceExpand(w) {
+			if err := x.word(b); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return x.fields, nil
+}
+
+// expandString expands a raw word without splitting it, which is what
+// redirection targets of dups and ${VAR:=word} need
+func (sh *Shell) expandString(raw string) (string, error) {
+	x := &expander{sh: sh, noSplit: true}
+	if err := x.word(raw); err != nil {
+		return "", err
+	}
+	return strings.Join(x.fields, " "), nil
+}
+
+// expandAssignment expands the raw value of an assignment, which is not
+// split but may contain several tilde prefixes
+func (sh *Shell) expandAssignment(raw string) (string, error) {
+	x := &expander{sh: sh, noSplit: true, assignment: true}
+	if err := x.word(raw); err != nil {
+		return "", err
+	}
+	return strings.Join(x.fields, " "), nil
+}
+
+// expandPattern expands a raw word into a pattern to match strings against,
+// as case does. Quoted parts of the word only match themselves.
+func (sh *Shell) expandPattern(raw string) (string, error) {
+	x := &expander{sh: sh, noSplit: true}
+	if err := x.parts([]rune(raw), false); err != nil {
+		return "", err
+	}
+	if x.cur == nil {
+		return "", nil
+	}
+	return x.cur.pat.String(), nil
+}
+
+// expandRegex expands a raw word into a regular expression, as the right
+// side of =~ in [[ ]]. Quoted parts of the word only match themselves.
+func (sh *Shell) expandRegex(raw string) (string, error) {
+	x := &expander{sh: sh, noSplit: true}
+	if err := x.parts([]rune(raw), false); err != nil {
+		return "", err
+	}
+	if x.cur == nil {
+		return "", nil
+	}
+	return x.cur.re.String(), nil
+}
+
+func (x *expander) field() *field {
+	if x.cur == nil {
+		x.cur = &field{}
+	}
+	return x.cur
+}
+
+// literal appends unquoted text that did not come from an expansion
+func (x *expander) literal(s string) {
+	x.field().add(s, false)
+	x.softBreak = false
+}
+
+// quoted appends text that is protected from splitting and globbing
+func (x *expander) quoted(s string) {
+	x.field().add(s, true)
+	x.softBreak = false
+}
+
+// endField finishes the current field. Empty unquoted fields disappear
+// unless keepEmpty is set.
+func (x *expander) endField(keepEmpty bool) {
+	switch {
+	case x.cur != nil && x.cur.glob && !x.noSplit && !x.sh.options["noglob"]:
+		x.globField(x.cur)
+	case x.cur != nil && (x.cur.sb.Len() > 0 || x.cur.quoted || keepEmpty):
+		x.fields = append(x.fields, x.cur.sb.String())
+	case x.cur == nil && keepEmpty:
+		x.fields = append(x.fields, "")
+	}
+	x.cur = nil
+}
+
+// globField adds the paths f matches as a pattern. Without matches the
+// pattern stays as it is, unless nullglob or failglob say otherwise.
+func (x *expander) globField(f *field) {
+	if matches := x.sh.glob(f.pat.String()); len(matches) > 0 {
+		x.fields = append(x.fields, matches...)
+		return
+	}
+	switch {
+	case x.sh.shopt["failglob"]:
+		if x.err == nil {
+			x.err = fmt.Errorf("traSH: no match: %s", f.sb.String())
+		}
+	case x.sh.shopt["nullglob"]:
+	default:
+		x.fields = append(x.fields, f.sb.String())
+	}
+}
+
+// value appends the result of an expansion, splitting it unless quoted
+func (x *expander) value(s string, inDouble bool) {
+	if inDouble {
+		x.quoted(s)
+	} else {
+		x.split(s)
+	}
+}
+
+// split appends s, starting a new field at every IFS separator
+func (x *expander) split(s string) {
+	ifs := x.sh.ifs()
+	if x.noSplit || ifs == "" {
+		x.literal(s)
+		return
+	}
+
+	for _, r := range s {
+		if !strings.ContainsRune(ifs, r) {
+			x.literal(string(r))
+			continue
+		}
+		if r == ' ' || r == '\t' || r == '\n' {
+			// Runs of whitespace separate fields but never create empty ones
+			if x.cur != nil && (x.cur.sb.Len() > 0 || x.cur.quoted) {
+				x.endField(false)
+				x.softBreak = true
+			}
+			continue
+		}
+		if x.softBreak && x.cur == nil {
+			x.softBreak = false
+			continue
+		}
+		x.endField(true)
+	}
+}
+
+// word expands a single raw word, which may turn into any number of fields
+func (x *expander) word(raw string) error {
+	if err := x.parts([]rune(raw), false); err != nil {
+		return err
+	}
+	x.endField(false)
+	x.softBreak = false
+	return x.err
+}
+
+// quotedBody returns what is between the quote at rs[i] and its closing
+// counterpart ending just before end
+func quotedBody(rs []rune, i, end int) []rune {
+	if end-1 > i && rs[end-1] == rs[i] {
+		return rs[i+1 : end-1]
+	}
+	return rs[i+1 : end]
+}
+
+// parts expands rs, which is either a whole word or the inside of a pair of
+// double quotes
+func (x *expander) parts(rs []rune, inDouble bool) error {
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		switch {
+		case c == '\'' && !inDouble:
+			end := parser.SkipQuoted(rs, i)
+			x.quoted(string(quotedBody(rs, i, end)))
+			i = end - 1
+
+		case c == '"' && !inDouble:
+			end := parser.SkipQuoted(rs, i)
+			body := quotedBody(rs, i, end)
+			// "$@" with no positional parameters expands to nothing at all,
+			// as does "${array[@]}" for an empty array; anything else in
+			// double quotes yields at least an empty field
+			if !isListExpansion(string(body)) {
+				x.field().quoted = true
+			}
+			if err := x.parts(body, true); err != nil {
+				return err
+			}
+			i = end - 1
+
+		case c == '~' && !inDouble && !x.arith && (i == 0 || (x.assignment && (rs[i-1] == ':' || rs[i-1] == '='))):
+			if end := x.tilde(rs, i); end > i {
+				i = end - 1
+			} else {
+				x.literal("~")
+			}
+
+		case c == '\\':
+			if i+1 >= len(rs) {
+				x.literal("\\")
+				continue
+			}
+			next := rs[i+1]
+			if inDouble && !strings.ContainsRune("$`\"\\\n", next) {
+				// Inside double quotes the backslash only escapes a few characters
+				x.quoted("\\")
+				continue
+			}
+			i++
+			if next != '\n' {
+				x.quoted(string(next))
+			}
+
+		case c == '`':
+			end := parser.SkipQuoted(rs, i)
+			if end > len(rs) || end-1 == i || rs[end-1] != '`' {
+				return fmt.Errorf("traSH: unexpected EOF while looking for matching ``'")
+			}
+			out, err := x.sh.commandSubst(backquoted(rs[i+1 : end-1]))
+			if err != nil {
+				return err
+			}
+			x.value(out, inDouble)
+			i = end - 1
+
+		case c == '$':
+			next, err := x.dollar(rs, i, inDouble)
+			if err != nil {
+				return err
+			}
+			i = next - 1
+
+		default:
+			switch {
+			case inDouble:
+				x.quoted(string(c))
+			case x.splitText:
+				x.split(string(c))
+			default:
+				x.literal(string(c))
+			}
+		}
+	}
+	return nil
+}
+
+func isNameRune(r rune, first bool) bool {
+	return r == '_' || unicode.IsLetter(r) || (!first && unicode.IsDigit(r))
+}
+
+// dollar expands the parameter starting with the "$" at rs[i] and returns
+// the index just past it
+func (x *expander) dollar(rs []rune, i int, inDouble bool) (int, error) {
+	if i+1 >= len(rs) {
+		x.loneDollar(inDouble)
+		return i + 1, nil
+	}
+
+	switch c := rs[i+1]; {
+	case c == '{':
+		end := parser.SkipQuoted(rs, i)
+		if end > len(rs) || rs[end-1] != '}' {
+			return end, fmt.Errorf("traSH: %s: bad substitution", string(rs[i:end]))
+		}
+		return end, x.braceParam(string(rs[i+2:end-1]), inDouble)
+
+	case c == '(':
+		end := parser.SkipQuoted(rs, i)
+		if end > len(rs) || rs[end-1] != ')' {
+			return end, fmt.Errorf("traSH: unexpected EOF while looking for matching `)'")
+		}
+		if i+2 < end-2 && rs[i+2] == '(' && rs[end-2] == ')' {
+			n, err := x.sh.arithmetic(string(rs[i+3 : end-2]))
+			if err != nil {
+				return end, err
+			}
+			x.value(strconv.FormatInt(n, 10), inDouble)
+			return end, nil
+		}
+		out, err := x.sh.commandSubst(string(rs[i+2 : end-1]))
+		if err != nil {
+			return end, err
+		}
+		x.value(out, inDouble)
+		return end, nil
+
+	case c == '@':
+		x.positional(inDouble)
+		return i + 2, nil
+
+	case strings.ContainsRune("*#?$!-", c) || unicode.IsDigit(c):
+		val, set := x.sh.lookup(string(c))
+		if err := x.checkSet(string(c), set); err != nil {
+			return i + 2, err
+		}
+		x.value(val, inDouble)
+		return i + 2, nil
+
+	case isNameRune(c, true):
+		j := i + 1
+		for j < len(rs) && isNameRune(rs[j], false) {
+			j++
+		}
+		val, set := x.sh.lookup(string(rs[i+1 : j]))
+		if err := x.checkSet(string(rs[i+1:j]), set); err != nil {
+			return j, err
+		}
+		x.value(val, inDouble)
+		return j, nil
+
+	default:
+		x.loneDollar(inDouble)
+		return i + 1, nil
+	}
+}
+
+// loneDollar appends a dollar sign that starts no expansion, which is just
+// that. Unquoted it still anchors a regular expression, as in [[ s =~ a$ ]].
+func (x *expander) loneDollar(inDouble bool) {
+	if inDouble {
+		x.quoted("$")
+	} else {
+		x.literal("$")
+	}
+}
+
+// unboundError is the error of expanding a variable that is not set under
+// set -u
+type unboundError string
+
+func (e unboundError) Error() string {
+	return "traSH: " + string(e) + ": unbound variable"
+}
+
+// nullParamError is the error of ${name:?message} when name is null or not
+// set
+type nullParamError struct {
+	name, msg string
+}
+
+func (e nullParamError) Error() string {
+	return "traSH: " + e.name + ": " + e.msg
+}
+
+// fatalExpansion reports whether err, from expanding a word, ends a shell
+// that is not interactive, as a missing variable does
+func fatalExpansion(err error) bool {
+	var unbound unboundError
+	var null nullParamError
+	return errors.As(err, &unbound) || errors.As(err, &null)
+}
+
+// checkSet fails under set -u when the parameter name is not set. $@ and
+// $* are fine without positional parameters.
+func (x *expander) checkSet(name string, set bool) error {
+	if set || !x.sh.options["nounset"] || name == "@" || name == "*" {
+		return nil
+	}
+	return unboundError(name)
+}
+
+// subscripted writes name with its subscript, if any, as in arr[2]
+func subscripted(name, sub string) string {
+	if sub == "" {
+		return name
+	}
+	return name + "[" + sub + "]"
+}
+
+// positional expands $@
+func (x *expander) positional(inDouble bool) {
+	x.list(x.sh.params, inDouble)
+}
+
+// list expands values the way $@ does: quoted, every value becomes a field
+// of its own even if it contains spaces
+func (x *expander) list(values []string, inDouble bool) {
+	if x.noSplit {
+		x.value(strings.Join(values, " "), inDouble)
+		return
+	}
+	for k, v := range values {
+		if k > 0 {
+			x.endField(inDouble)
+		}
+		x.value(v, inDouble)
+	}
+}
+
+// isListExpansion reports whether s is nothing but $@, ${@} or ${name[@]}
+func isListExpansion(s string) bool {
+	if s == "$@" || s == "${@}" {
+		return true
+	}
+	if !strings.HasPrefix(s, "${") || !strings.HasSuffix(s, "[@]}") {
+		return false
+	}
+	return parser.IsName(s[2 : len(s)-4])
+}
+
+// paramName splits the parameter name off the front of a ${...} body
+func paramName(body string) (name, rest string) {
+	if body == "" {
+		return "", ""
+	}
+	rs := []rune(body)
+	switch {
+	case unicode.IsDigit(rs[0]):
+		j := 0
+		for j < len(rs) && unicode.IsDigit(rs[j]) {
+			j++
+		}
+		return string(rs[:j]), string(rs[j:])
+	case strings.ContainsRune("@*#?$!-", rs[0]):
+		return string(rs[0]), string(rs[1:])
+	case isNameRune(rs[0], true):
+		j := 1
+		for j < len(rs) && isNameRune(rs[j], false) {
+			j++
+		}
+		return string(rs[:j]), string(rs[j:])
+	}
+	return "", body
+}
+
+// subscript splits an array subscript such as [2] or [@] off the front of
+// rest, returning what is between the brackets
+func subscript(rest string) (sub, after string, ok bool) {
+	if !strings.HasPrefix(rest, "[") {
+		return "", rest, true
+	}
+	end := strings.IndexByte(rest, ']')
+	if end < 0 {
+		return "", rest, false
+	}
+	return rest[1:end], rest[end+1:], true
+}
+
+// param looks up a parameter, possibly with a subscript. For $@, $* and
+// ${name[@]} or ${name[*]} it also returns the individual values, and
+// spread tells whether they expand to separate fields.
+func (x *expander) param(name, sub string) (val string, values []string, spread, set bool, err error) {
+	switch {
+	case name == "@" || name == "*":
+		values = x.sh.params
+		val, _ = x.sh.lookup(name)
+		return val, values, name == "@", len(values) > 0, nil
+
+	case sub == "@" || sub == "*":
+		values, _ = x.sh.vars.GetArray(name)
+		if values == nil {
+			values = []string{}
+		}
+		val = strings.Join(values, x.sh.ifsJoiner())
+		return val, values, sub == "@", len(values) > 0, nil
+
+	case sub != "":
+		expanded, err := x.sh.expandString(sub)
+		if err != nil {
+			return "", nil, false, false, err
+		}
+		i, err := strconv.Atoi(strings.TrimSpace(expanded))
+		if err != nil {
+			return "", nil, false, false, fmt.Errorf("traSH: %s: bad array subscript", sub)
+		}
+		arr, _ := x.sh.vars.GetArray(name)
+		if i < 0 {
+			i += len(arr)
+		}
+		if i < 0 || i >= len(arr) {
+			return "", nil, false, false, nil
+		}
+		return arr[i], nil, false, true, nil
+	}
+
+	val, set = x.sh.lookup(name)
+	return val, nil, false, set, nil
+}
+
+// braceParam expands the body of ${...}: plain ${VAR}, array elements
+// ${VAR[i]} and ${VAR[@]}, the length ${#VAR}, and the default/alternate
+// forms ${VAR:-word}, ${VAR:=word}, ${VAR:?message} and ${VAR:+word}, each
+// also without the colon, in which case only an unset variable counts as
+// missing rather than an empty one
+func (x *expander) braceParam(body string, inDouble bool) error {
+	bad := fmt.Errorf("traSH: ${%s}: bad substitution", body)
+
+	if len(body) > 1 && body[0] == '#' {
+		name, rest := paramName(body[1:])
+		sub, rest, ok := subscript(rest)
+		if name == "" || rest != "" || !ok {
+			return bad
+		}
+		val, values, _, set, err := x.param(name, sub)
+		if err != nil {
+			return err
+		}
+		if err := x.checkSet(subscripted(name, sub), set || values != nil); err != nil {
+			return err
+		}
+		if values != nil {
+			x.value(strconv.Itoa(len(values)), inDouble)
+		} else {
+			x.value(strconv.Itoa(len([]rune(val))), inDouble)
+		}
+		return nil
+	}
+
+	name, rest := paramName(body)
+	sub, rest, ok := subscript(rest)
+	if name == "" || !ok {
+		return bad
+	}
+	val, values, spread, set, err := x.param(name, sub)
+	if err != nil {
+		return err
+	}
+
+	expandValue := func() {
+		if spread {
+			x.list(values, inDouble)
+		} else {
+			x.value(val, inDouble)
+		}
+	}
+
+	if rest == "" {
+		if err := x.checkSet(subscripted(name, sub), set || values != nil); err != nil {
+			return err
+		}
+		expandValue()
+		return nil
+	}
+
+	colon := rest[0] == ':'
+	op := strings.TrimPrefix(rest, ":")
+	if op == "" {
+		return bad
+	}
+	word := []rune(op[1:])
+	missing := !set || (colon && val == "")
+
+	switch op[0] {
+	case '-':
+		if missing {
+			return x.paramWord(word, inDouble)
+		}
+		expandValue()
+
+	case '=':
+		if !missing {
+			expandValue()
+			return nil
+		}
+		if !parser.IsName(name) || sub != "" {
+			return fmt.Errorf("traSH: $%s: cannot assign in this way", name)
+		}
+		w, err := x.sh.expandString(string(word))
+		if err != nil {
+			return err
+		}
+		x.sh.vars.Set(name, w)
+		x.value(w, inDouble)
+
+	case '?':
+		if !missing {
+			expandValue()
+			return nil
+		}
+		msg, err := x.sh.expandString(string(word))
+		if err != nil {
+			return err
+		}
+		if msg == "" {
+			msg = "parameter null or not set"
+		}
+		return nullParamError{name: name, msg: msg}
+
+	case '+':
+		if !missing {
+			return x.paramWord(word, inDouble)
+		}
+
+	default:
+		return bad
+	}
+	return nil
+}
+
+// paramWord expands the word of ${VAR:-word} or ${VAR:+word}. Outside double
+// quotes its unquoted text is split just like the value of a variable would
+// be. Inside them, double quotes in the word are removed rather than kept,
+// so that "${VAR:-"a b"}" is the same as "${VAR:-a b}".
+func (x *expander) paramWord(word []rune, inDouble bool) error {
+	if !inDouble {
+		saved := x.splitText
+		x.splitText = true
+		defer func() { x.splitText = saved }()
+		return x.parts(word, false)
+	}
+
+	var unquoted []rune
+	for i := 0; i < len(word); {
+		end := i + 1
+		switch word[i] {
+		case '"':
+			i++
+			continue
+		case '\\':
+			end = min(i+2, len(word))
+		case '$', '`':
+			end = min(parser.SkipQuoted(word, i), len(word))
+		}
+		unquoted = append(unquoted, word[i:end]...)
+		i = end
+	}
+	return x.parts(unquoted, true)
+}
+
+// declarationBuiltins take NAME=value arguments that expand like assignments
+var declarationBuiltins = map[string]bool{
+	"export":  true,
+	"local":   true,
+	"declare": true,
+}
+
+// expandCommand expands the words, assignments and redirection targets of
+// c into a Command ready to be run
+func (sh *Shell) expandCommand(c *parser.SimpleCommand) (*Command, error) {
+	x := &Command{sh: sh}
+
+	for _, a := range c.Assigns {
+		name, raw, _ := strings.Cut(a.Raw, "=")
+		value, err := sh.expandAssignment(raw)
+		if err != nil {
+			return nil, err
+		}
+		x.env = append(x.env, name+"="+value)
+	}
+
+	var fields []string
+	for _, w := range c.Words {
+		// Like assignments, NAME=value arguments of export are not split
+		if len(fields) > 0 && declarationBuiltins[fields[0]] && parser.IsAssignment(w.Raw) {
+			value, err := sh.expandAssignment(w.Raw)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, value)
+			continue
+		}
+		expanded, err := sh.expandWords([]string{w.Raw})
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, expanded...)
+	}
+	x.setWords(fields)
+
+	for _, r := range c.Redirs {
+		target, err := sh.expandRedirectTarget(r)
+		if err != nil {
+			return nil, err
+		}
+		x.redirs = append(x.redirs, redirect{fd: r.Fd, op: r.Op, target: target})
+	}
+
+	return x, nil
+}
+
+// expandRedirectTarget expands the target of r, which must come out as a
+// single word unless it names a descriptor to duplicate
+func (sh *Shell) expandRedirectTarget(r *parser.Redirect) (string, error) {
+	if r.Op == "<&" || r.Op == ">&" {
+		return sh.expandString(r.Target.Raw)
+	}
+	targets, err := sh.expandWords([]string{r.Target.Raw})
+	if err != nil {
+		return "", err
+	}
+	if len(targets) != 1 {
+		return "", fmt.Errorf("traSH: %s: ambiguous redirect", r.Target.Raw)
+	}
+	return targets[0], nil
+}
@@ -0,0 +1,101 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mush1e/traSH/internal/parser"
+)
+
+// HandleExport marks variables as exported so child processes see them,
+// optionally assigning them first. Without names it lists the environment.
+func HandleExport(cmd *Command) error {
+	sh := cmd.sh
+	unexport := false
+	var names []string
+	for _, arg := range cmd.args {
+		switch {
+		case arg == "-n":
+			unexport = true
+		case arg == "-p" || arg == "--":
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("%s: invalid option\nusage: export [-n] [name[=value] ...] or export -p", arg)
+		default:
+			names = append(names, arg)
+		}
+	}
+
+	if len(names) == 0 {
+		for _, name := range sh.vars.ExportedNames() {
+			if value, ok := sh.vars.Get(name); ok {
+				fmt.Fprintf(cmd.out(), "export %s=%s\n", name, shellQuote(value))
+			} else {
+				fmt.Fprintf(cmd.out(), "export %s\n", name)
+			}
+		}
+		return nil
+	}
+
+	failed := false
+	for _, arg := range names {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !parser.IsName(name) {
+			fmt.Fprintf(cmd.errOut(), "traSH: export: `%s': not a valid identifier\n", arg)
+			failed = true
+			continue
+		}
+		if hasValue {
+			sh.vars.Set(name, value)
+		}
+		if unexport {
+			sh.vars.Unexport(name)
+		} else {
+			sh.vars.Export(name)
+		}
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// HandleUnset removes shell variables, or functions with -f
+func HandleUnset(cmd *Command) error {
+	sh := cmd.sh
+	funcs, vars := false, false
+	failed := false
+	for _, name := range cmd.args {
+		switch name {
+		case "-f":
+			funcs = true
+			continue
+		case "-v":
+			vars = true
+			continue
+		case "--":
+			continue
+		}
+
+		if funcs {
+			delete(sh.funcs, name)
+			continue
+		}
+		if !parser.IsName(name) {
+			fmt.Fprintf(cmd.errOut(), "traSH: unset: `%s': not a valid identifier\n", name)
+			failed = true
+			continue
+		}
+		// Without -v a name that isn't a variable may still be a function
+		if _, set := sh.vars.Get(name); !set && !vars {
+			delete(sh.funcs, name)
+			continue
+		}
+		sh.vars.Unset(name)
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
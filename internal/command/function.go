@@ -0,0 +1,203 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mush1e/traSH/internal/parser"
+)
+
+// maxFuncDepth stops runaway recursion before it takes the shell down
+const maxFuncDepth = 1000
+
+// returnRequest is what the return builtin returns to end the function it
+// was called from with the given status
+type returnRequest int
+
+func (r returnRequest) Error() string {
+	return "return"
+}
+
+// defineFunction makes f callable by its name
+func (sh *Shell) defineFunction(f *parser.FuncDecl) {
+	sh.funcs[f.Name] = f
+}
+
+// isFunction reports whether name is a defined function
+func (sh *Shell) isFunction(name string) bool {
+	_, ok := sh.funcs[name]
+	return ok
+}
+
+// inProcess reports whether name runs inside the shell rather than as a
+// child process, as builtins and functions do
+func (sh *Shell) inProcess(name string) bool {
+	return isBuiltin(name) || sh.isFunction(name)
+}
+
+// callFunction runs f with the arguments of cmd as positional parameters.
+// Redirections on the call apply to the whole body, and local variables
+// declared in it disappear once it returns.
+func (sh *Shell) callFunction(f *parser.FuncDecl, cmd *Command) (int, error) {
+	if sh.funcDepth >= maxFuncDepth {
+		return 1, fmt.Errorf("traSH: %s: maximum function nesting level exceeded (%d)", f.Name, maxFuncDepth)
+	}
+
+	closeRedirects, err := applyRedirects(cmd)
+	if err != nil {
+		return 1, err
+	}
+	defer closeRedirects()
+
+	// NAME=value in front of the call lasts for the call
+	if len(cmd.env) > 0 {
+		defer sh.vars.setTemporarily(cmd.env)()
+	}
+
+	stdin, stdout, stderr := sh.stdin, sh.stdout, sh.stderr
+	params, loopDepth := sh.params, sh.loopDepth
+	sh.stdin, sh.stdout, sh.stderr = cmd.in(), cmd.out(), cmd.errOut()
+	sh.params = append([]string(nil), cmd.args...)
+	// break and continue never reach loops outside the function
+	sh.loopDepth = 0
+	sh.funcDepth++
+	sh.vars.PushScope()
+	defer func() {
+		sh.vars.PopScope()
+		sh.funcDepth--
+		sh.stdin, sh.stdout, sh.stderr = stdin, stdout, stderr
+		sh.params, sh.loopDepth = params, loopDepth
+	}()
+
+	status, err := sh.runCompound(f.Body)
+	var ret returnRequest
+	if errors.As(err, &ret) {
+		return int(ret), nil
+	}
+	return status, err
+}
+
+// HandleReturn ends the running function or sourced file with the given
+// status, or with that of the last command
+func HandleReturn(cmd *Command) error {
+	sh := cmd.sh
+	if sh == nil || sh.funcDepth == 0 && sh.sourceDepth == 0 {
+		return errors.New("can only `return' from a function or sourced script")
+	}
+	if len(cmd.args) == 0 {
+		return returnRequest(sh.last.Code)
+	}
+	n, err := strconv.Atoi(cmd.args[0])
+	if err != nil {
+		fmt.Fprintf(cmd.errOut(), "traSH: return: %s: numeric argument required\n", cmd.args[0])
+		return returnRequest(2)
+	}
+	return returnRequest(n & 0xff)
+}
+
+// HandleLocal declares variables local to the running function, assigning
+// them when given as NAME=value. Without arguments it lists them.
+func HandleLocal(cmd *Command) error {
+	sh := cmd.sh
+	if sh == nil || sh.funcDepth == 0 {
+		return errors.New("can only be used in a function")
+	}
+
+	if len(cmd.args) == 0 {
+		for _, name := range sh.vars.LocalNames() {
+			value, _ := sh.vars.Get(name)
+			fmt.Fprintf(cmd.out(), "%s=%s\n", name, shellQuote(value))
+		}
+		return nil
+	}
+	return declareVars(cmd, cmd.args, true)
+}
+
+// declareVars sets up each NAME or NAME=value in args, as a local variable
+// when local is set
+func declareVars(cmd *Command, args []string, local bool) error {
+	sh := cmd.sh
+	failed := false
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !parser.IsName(name) {
+			fmt.Fprintf(cmd.errOut(), "traSH: %s: `%s': not a valid identifier\n", cmd.command, arg)
+			failed = true
+			continue
+		}
+		if local {
+			sh.vars.Local(name)
+		}
+		if hasValue {
+			sh.vars.Set(name, value)
+		}
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// HandleDeclare lists functions with -f (their names only with -F), or
+// declares variables, which are local when used inside a function
+func HandleDeclare(cmd *Command) error {
+	sh := cmd.sh
+	var funcs, namesOnly bool
+	var args []string
+	for i, arg := range cmd.args {
+		if arg == "--" {
+			args = append(args, cmd.args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" || len(args) > 0 {
+			args = append(args, arg)
+			continue
+		}
+		for _, opt := range arg[1:] {
+			switch opt {
+			case 'f':
+				funcs = true
+			case 'F':
+				funcs, namesOnly = true, true
+			default:
+				return fmt.Errorf("-%c: invalid option\nusage: declare [-fF] [name[=value] ...]", opt)
+			}
+		}
+	}
+
+	if !funcs {
+		if len(args) == 0 {
+			return HandleSet(&Command{command: "set", files: cmd.files, sh: sh})
+		}
+		return declareVars(cmd, args, sh.funcDepth > 0)
+	}
+
+	names := args
+	if len(names) == 0 {
+		for name := range sh.funcs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	failed := false
+	for _, name := range names {
+		f, ok := sh.funcs[name]
+		switch {
+		case !ok:
+			failed = true
+		case namesOnly:
+			fmt.Fprintf(cmd.out(), "declare -f %s\n", name)
+		default:
+			fmt.Fprintln(cmd.out(), f.String())
+		}
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package command
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// hasGlobMeta reports whether pat contains an unescaped *, ? or [
+func hasGlobMeta(pat string) bool {
+	for i := 0; i < len(pat); i++ {
+		switch pat[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeGlob drops the backslashes that keep metacharacters literal
+func unescapeGlob(pat string) string {
+	if !strings.Contains(pat, `\`) {
+		return pat
+	}
+	var sb strings.Builder
+	for i := 0; i < len(pat); i++ {
+		if pat[i] == '\\' && i+1 < len(pat) {
+			i++
+		}
+		sb.WriteByte(pat[i])
+	}
+	return sb.String()
+}
+
+// escapeGlob makes every metacharacter in s literal
+func escapeGlob(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// matchGlob reports whether name matches pat, in which * matches any string,
+// ? any single character, [...] any character in the set, and a backslash
+// makes the following character literal
+func matchGlob(pat, name []rune) bool {
+	for len(pat) > 0 {
+		switch pat[0] {
+		case '*':
+			for len(pat) > 0 && pat[0] == '*' {
+				pat = pat[1:]
+			}
+			if len(pat) == 0 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(pat, name[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(name) == 0 {
+				return false
+			}
+			pat, name = pat[1:], name[1:]
+			continue
+
+		case '[':
+			if len(name) == 0 {
+				return false
+			}
+			if matched, width, ok := matchBracket(pat, name[0]); ok {
+				if !matched {
+					return false
+				}
+				pat, name = pat[width:], name[1:]
+				continue
+			}
+			// An unterminated [ is just a bracket
+
+		case '\\':
+			if len(pat) > 1 {
+				pat = pat[1:]
+			}
+		}
+
+		if len(name) == 0 || pat[0] != name[0] {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// charClasses are the [:name:] classes allowed inside brackets
+var charClasses = map[string]func(rune) bool{
+	"alnum":  func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) },
+	"alpha":  unicode.IsLetter,
+	"blank":  func(r rune) bool { return r == ' ' || r == '\t' },
+	"cntrl":  unicode.IsControl,
+	"digit":  unicode.IsDigit,
+	"lower":  unicode.IsLower,
+	"print":  unicode.IsPrint,
+	"punct":  unicode.IsPunct,
+	"space":  unicode.IsSpace,
+	"upper":  unicode.IsUpper,
+	"xdigit": func(r rune) bool { return strings.ContainsRune("0123456789abcdefABCDEF", r) },
+}
+
+// matchBracket matches r against the bracket expression at the start of
+// pat. It returns whether r is in the set and the width of the expression,
+// or ok=false if the expression is never closed.
+func matchBracket(pat []rune, r rune) (matched bool, width int, ok bool) {
+	i := 1
+	negate := i < len(pat) && (pat[i] == '!' || pat[i] == '^')
+	if negate {
+		i++
+	}
+
+	for first := true; i < len(pat); first = false {
+		c := pat[i]
+		if c == ']' && !first {
+			return matched != negate, i + 1, true
+		}
+
+		if c == '[' && i+1 < len(pat) && pat[i+1] == ':' {
+			if end := indexRunes(pat[i+2:], ":]"); end >= 0 {
+				name := string(pat[i+2 : i+2+end])
+				if class, known := charClasses[name]; known && class(r) {
+					matched = true
+				}
+				i += end + 4
+				continue
+			}
+		}
+
+		if c == '\\' && i+1 < len(pat) {
+			i++
+			c = pat[i]
+		}
+		lo, hi := c, c
+		if i+2 < len(pat) && pat[i+1] == '-' && pat[i+2] != ']' {
+			hi = pat[i+2]
+			if hi == '\\' && i+3 < len(pat) {
+				i++
+				hi = pat[i+2]
+			}
+			i += 2
+		}
+		if lo <= r && r <= hi {
+			matched = true
+		}
+		i++
+	}
+	return false, 0, false
+}
+
+// indexRunes returns the index of the first sub in rs, or -1
+func indexRunes(rs []rune, sub string) int {
+	n := len([]rune(sub))
+	for i := 0; i+n <= len(rs); i++ {
+		if string(rs[i:i+n]) == sub {
+			return i
+		}
+	}
+	return -1
+}
+
+// glob returns the paths matching pattern in sorted order, or nil if there
+// are none. Hidden files only match a pattern that starts with a dot unless
+// dotglob is set, and ** matches any number of directories if globstar is.
+func (sh *Shell) glob(pattern string) []string {
+	// Each prefix is a path that matched so far, ending in a slash unless
+	// it is empty
+	prefixes := []string{""}
+	if strings.HasPrefix(pattern, "/") {
+		prefixes = []string{"/"}
+		pattern = strings.TrimLeft(pattern, "/")
+	}
+
+	comps := strings.Split(pattern, "/")
+	for i, comp := range comps {
+		last := i == len(comps)-1
+		var next []string
+
+		switch {
+		case comp == "":
+			// A trailing slash only leaves directories, a doubled one is ignored
+			if last {
+				for _, p := range prefixes {
+					if p != "" {
+						next = append(next, p)
+					}
+				}
+			} else {
+				next = prefixes
+			}
+
+		case comp == "**" && sh.shopt["globstar"]:
+			for _, p := range prefixes {
+				if !last {
+					next = append(next, p)
+				}
+				next = append(next, sh.walk(p, last)...)
+			}
+
+		case !hasGlobMeta(comp):
+			name := unescapeGlob(comp)
+			for _, p := range prefixes {
+				if _, err := os.Lstat(sh.path(p + name)); err == nil {
+					next = append(next, joinMatch(p, name, last))
+				}
+			}
+
+		default:
+			pat := []rune(comp)
+			dotted := strings.HasPrefix(comp, ".") || strings.HasPrefix(comp, `\.`)
+			for _, p := range prefixes {
+				for _, name := range readDirNames(sh.path(p)) {
+					if name[0] == '.' && !dotted && !sh.shopt["dotglob"] {
+						continue
+					}
+					if !matchGlob(pat, []rune(name)) {
+						continue
+					}
+					if !last && !isDir(sh.path(p+name)) {
+						continue
+					}
+					next = append(next, joinMatch(p, name, last))
+				}
+			}
+		}
+
+		prefixes = next
+		if len(prefixes) == 0 {
+			return nil
+		}
+	}
+
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// walk returns every directory below prefix, or with files set every
+// entry, each as a match for **
+func (sh *Shell) walk(prefix string, files bool) []string {
+	var found []string
+	for _, name := range readDirNames(sh.path(prefix)) {
+		if name[0] == '.' && !sh.shopt["dotglob"] {
+			continue
+		}
+		path := prefix + name
+		dir := isDir(sh.path(path))
+		if !dir && !files {
+			continue
+		}
+		found = append(found, joinMatch(prefix, name, files))
+		if dir {
+			// Don't follow symlinks, which might lead in circles
+			if info, err := os.Lstat(sh.path(path)); err == nil && info.Mode()&os.ModeSymlink == 0 {
+				found = append(found, sh.walk(path+"/", files)...)
+			}
+		}
+	}
+	return found
+}
+
+// joinMatch appends name to prefix, followed by a slash unless it is the
+// last component
+func joinMatch(prefix, name string, last bool) string {
+	if last {
+		return prefix + name
+	}
+	return prefix + name + "/"
+}
+
+func readDirNames(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name()
+	}
+	return names
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
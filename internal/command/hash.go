@@ -0,0 +1,331 @@
+package command
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultPath is searched by command -p, and is sure to find the standard
+// utilities whatever PATH says
+const defaultPath = "/usr/bin:/bin:/usr/sbin:/sbin"
+
+// commandCache remembers where commands were found in PATH, so that
+// running one again skips the search, and which executables each PATH
+// directory holds, for completion. Each shell has its own, which a
+// subshell gets a copy of.
+type commandCache struct {
+	mu sync.Mutex
+	// path is the PATH the hashed entries were found with; they are
+	// forgotten as soon as it changes
+	path   string
+	hashed map[string]*hashEntry
+	// dirs lists the executables of each directory read so far, read
+	// again when the directory changes
+	dirs map[string]*dirListing
+}
+
+type hashEntry struct {
+	path string
+	hits int
+}
+
+type dirListing struct {
+	modTime time.Time
+	names   []string
+}
+
+func newCommandCache() *commandCache {
+	return &commandCache{
+		hashed: make(map[string]*hashEntry),
+		dirs:   make(map[string]*dirListing),
+	}
+}
+
+// clone returns a copy of c that can change without affecting c
+func (c *commandCache) clone() *commandCache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	copied := &commandCache{
+		path:   c.path,
+		hashed: make(map[string]*hashEntry, len(c.hashed)),
+		// Listings are replaced rather than changed, so they can be shared
+		dirs: maps.Clone(c.dirs),
+	}
+	for name, e := range c.hashed {
+		entry := *e
+		copied.hashed[name] = &entry
+	}
+	return copied
+}
+
+// forked returns a copy of sh to run an external command from as a forked
+// child would, looking the command up in a hash table that sh never sees
+func (sh *Shell) forked() *Shell {
+	f := *sh
+	f.commands = sh.commands.clone()
+	return &f
+}
+
+// sync forgets the hashed entries when PATH is no longer the one they were
+// found with. c.mu must be held.
+func (c *commandCache) sync(path string) {
+	if path != c.path {
+		c.path = path
+		clear(c.hashed)
+	}
+}
+
+// listing returns the names of the executables in dir. c.mu must be held.
+func (c *commandCache) listing(dir string) []string {
+	info, err := os.Stat(dir)
+	if err != nil {
+		delete(c.dirs, dir)
+		return nil
+	}
+	if l, ok := c.dirs[dir]; ok && l.modTime.Equal(info.ModTime()) {
+		return l.names
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		if isExecutable(filepath.Join(dir, e.Name())) {
+			names = append(names, e.Name())
+		}
+	}
+	c.dirs[dir] = &dirListing{modTime: info.ModTime(), names: names}
+	return names
+}
+
+// isExecutable reports whether path is a file that can be run
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0
+}
+
+// searchPath looks for name in each directory of path and returns where
+// it was found: only the first place, or every one when all is set
+func (sh *Shell) searchPath(name, path string, all bool) []string {
+	var found []string
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		full := filepath.Join(dir, name)
+		if !strings.Contains(full, "/") {
+			// Keep a slash in it, or it would be looked up all over again
+			full = "./" + full
+		}
+		if isExecutable(sh.path(full)) {
+			found = append(found, full)
+			if !all {
+				break
+			}
+		}
+	}
+	return found
+}
+
+// hashedPath returns where the command name was found before, if it still
+// is there
+func (sh *Shell) hashedPath(name string) (string, bool) {
+	path, _ := sh.vars.Get("PATH")
+	c := sh.commands
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sync(path)
+	if e, ok := c.hashed[name]; ok && isExecutable(sh.path(e.path)) {
+		return e.path, true
+	}
+	return "", false
+}
+
+// lookPath finds the executable the command name runs, searching PATH
+// unless it is hashed already, and remembers it. run counts it as a use,
+// as hash shows.
+func (sh *Shell) lookPath(name string, run bool) (string, bool) {
+	path, _ := sh.vars.Get("PATH")
+	c := sh.commands
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sync(path)
+	e, ok := c.hashed[name]
+	if !ok || !isExecutable(sh.path(e.path)) {
+		found := sh.searchPath(name, path, false)
+		if len(found) == 0 {
+			delete(c.hashed, name)
+			return "", false
+		}
+		e = &hashEntry{path: found[0]}
+		c.hashed[name] = e
+	}
+	if run {
+		e.hits++
+	}
+	return e.path, true
+}
+
+// Executables returns the names of the commands in PATH, as offered by
+// completion. A directory is only read again once it changes.
+func (sh *Shell) Executables() []string {
+	path, _ := sh.vars.Get("PATH")
+	c := sh.commands
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		for _, name := range c.listing(sh.path(dir)) {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// HandleHash lists the commands the shell remembers the location of, with
+// how often each was run. Names given are looked up and remembered; -r
+// forgets everything, -d forgets the names, -t prints where they are, -p
+// path name says where name is, and -l lists in a form hash reads back.
+func HandleHash(cmd *Command) error {
+	sh := cmd.sh
+	var reset, forget, show, reuse bool
+	var setPath string
+	args := cmd.args
+
+options:
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for i := 1; i < len(arg); i++ {
+			switch arg[i] {
+			case 'r':
+				c := sh.commands
+				c.mu.Lock()
+				clear(c.hashed)
+				clear(c.dirs)
+				c.mu.Unlock()
+				reset = true
+			case 'd':
+				forget = true
+			case 't':
+				show = true
+			case 'l':
+				reuse = true
+			case 'p':
+				if len(args) == 0 {
+					return fmt.Errorf("-p: option requires an argument\nusage: hash [-lr] [-p pathname] [-dt] [name ...]")
+				}
+				setPath, args = args[0], args[1:]
+				continue options
+			default:
+				return fmt.Errorf("-%c: invalid option\nusage: hash [-lr] [-p pathname] [-dt] [name ...]", arg[i])
+			}
+		}
+	}
+
+	path, _ := sh.vars.Get("PATH")
+	c := sh.commands
+	if len(args) == 0 {
+		if show {
+			return fmt.Errorf("-t: option requires an argument")
+		}
+		if reset || forget || setPath != "" {
+			// Only a plain hash or hash -l lists the table
+			return nil
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.sync(path)
+		printHashed(cmd, c.hashed, reuse)
+		return nil
+	}
+
+	failed := false
+	for _, name := range args {
+		switch {
+		case setPath != "":
+			c.mu.Lock()
+			c.sync(path)
+			c.hashed[name] = &hashEntry{path: setPath}
+			c.mu.Unlock()
+		case forget:
+			c.mu.Lock()
+			_, ok := c.hashed[name]
+			delete(c.hashed, name)
+			c.mu.Unlock()
+			if !ok {
+				fmt.Fprintf(cmd.errOut(), "traSH: hash: %s: not found\n", name)
+				failed = true
+			}
+		case show:
+			p, ok := sh.hashedPath(name)
+			switch {
+			case !ok:
+				fmt.Fprintf(cmd.errOut(), "traSH: hash: %s: not found\n", name)
+				failed = true
+			case len(args) > 1:
+				fmt.Fprintf(cmd.out(), "%s\t%s\n", name, p)
+			default:
+				fmt.Fprintln(cmd.out(), p)
+			}
+		case isBuiltin(name) || sh.isFunction(name) || strings.Contains(name, "/"):
+			// Nothing to look up
+		default:
+			if _, ok := sh.lookPath(name, false); !ok {
+				fmt.Fprintf(cmd.errOut(), "traSH: hash: %s: not found\n", name)
+				failed = true
+			}
+		}
+	}
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// printHashed writes out the hash table, sorted by command name
+func printHashed(cmd *Command, hashed map[string]*hashEntry, reuse bool) {
+	if len(hashed) == 0 {
+		fmt.Fprintln(cmd.out(), "hash: hash table empty")
+		return
+	}
+	names := make([]string, 0, len(hashed))
+	for name := range hashed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if !reuse {
+		fmt.Fprintln(cmd.out(), "hits\tcommand")
+	}
+	for _, name := range names {
+		e := hashed[name]
+		if reuse {
+			fmt.Fprintf(cmd.out(), "hash -p %s %s\n", e.path, name)
+		} else {
+			fmt.Fprintf(cmd.out(), "%4d\t%s\n", e.hits, e.path)
+		}
+	}
+}
@@ -1,28 +1,87 @@
 package command
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+)
 
-func printHelp() {
+func HandleHelp(cmd *Command) error {
+	printHelp(cmd.out())
+	return nil
+}
+
+func printHelp(w io.Writer) {
 	help := `traSH - Built-in Commands:
 
 Built-ins:
-  cd <dir>     Change directory
+  cd [dir]     Change directory (cd - for the previous one, -P resolves symlinks)
   help/?       Show this help
-  exit         Exit the shell
+  exit [n]     Exit the shell with status n
+  jobs [-lp]   List background and stopped jobs
+  fg [%n]      Bring a job to the foreground
+  bg [%n]      Continue a stopped job in the background
+  wait [%n]    Wait for background jobs to finish
+  export       Export variables to child processes
+  unset        Remove variables
+  set          List variables, set positional parameters, or options: -e -u -x -C -f -o pipefail
+  env          Print the environment or run a command in a modified one
+  shopt        Toggle globstar, nullglob, failglob and dotglob; shopt -o for set options
+  break [n]    Leave the innermost loop, or n loops
+  continue [n] Skip to the next iteration of the innermost loop, or the nth one out
+  return [n]   Return from a function with status n
+  local        Declare variables local to a function
+  declare -f   List functions (-F for names only), unset -f removes one
+  alias        Define or list aliases (alias ll='ls -la'), unalias removes them
+  let expr     Evaluate arithmetic, as does ((expr))
+  trap cmd SIG Run cmd on a signal or on EXIT, ERR, DEBUG; trap -p lists, trap - SIG resets
+  pushd dir    Change to dir, saving the old one; popd returns, dirs -v lists, ~N expands
+  source file  Run file in this shell (also .), with any further arguments as $1...
+  type -a x    Tell whether x is an alias, keyword, function, builtin or file; which finds files
+  command x    Run x even if a function shares its name; command -v x prints what x runs
+  hash [-r]    List where commands were found in PATH; -r forgets them
+  read -r x y  Read a line and split it at IFS into x and y; -p prompt, -s, -t secs, -n N, -d c, -a arr
+  test -f x    Check files, strings and numbers (also [ ... ]): -d -x -nt = != -lt ! -a -o
+  shift [n]    Drop the first n positional parameters, so that $2 becomes $1
+  : [args]     Do nothing and succeed, as in while :; do ...; done
 
 Features:
   • Arrow keys for cursor movement
   • Quoted arguments: "hello world"
   • Command options: -n, --verbose
   • External command support
-  • Escape sequences in quotes: "line1\nline2"
+  • Pipelines: ps aux | grep go | wc -l
+  • Redirection: > >> < 2> 2>&1 &> N>&M
+  • Command lists: cmd1; cmd2, cmd1 && cmd2, cmd1 || cmd2
+  • Job control: cmd &, Ctrl-Z to suspend, Ctrl-C to interrupt (not traSH)
+  • Variables: $HOME, "${PATH}", $1, ${VAR:-default}, ${VAR:=x}, ${VAR:?msg}
+  • Exit status: $? for the last command, ${PIPESTATUS[@]} for every stage
+  • No expansion inside single quotes: '$HOME'
+  • Command substitution: $(date), "$(git branch --show-current)"
+  • Brace expansion: src/{cmd,pkg}, {01..20}, {a..e}, {0..100..10}
+  • Tilde expansion: ~, ~/src, ~user, ~+ (PWD), ~- (OLDPWD)
+  • Globbing: *.go, file?.txt, [a-z]*, **/*.go (with shopt -s globstar)
+  • Control flow: if/elif/else/fi, while, until, for ... in, case ... esac,
+    typed on one line or across several
+  • Grouping: { cmd1; cmd2; } > out runs in the shell, (cd dir && make) in
+    a subshell whose cd and variables don't leak back
+  • Functions: name() { ...; } with $1, $@, $# and local variables
+  • Arithmetic: $((x * 2)), ((i++)), 16#ff, 2**10, a ? b : c
+  • Conditionals: [[ $f == *.go && -s $f ]], [[ $v =~ ^([0-9]+)\.([0-9]+)$ ]]
+    fills BASH_REMATCH
 
 Examples:
   cd "My Documents"
   echo -n "Hello, World!"
   grep "error message" /var/log/app.log
   ls -la
+  ls -la | sort -k5 -n | tail -3
+  make build > build.log 2>&1
+  make build && ./bin/traSH
   mkdir "New Folder"
+  export EDITOR=vim; echo "editing with $EDITOR"
+  cd "$(git rev-parse --show-toplevel)"
+  for f in *.log; do gzip "$f"; done
+  mkcd() { mkdir -p "$1" && cd "$1"; }
 `
-	fmt.Print(help)
+	fmt.Fprint(w, help)
 }
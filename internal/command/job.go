@@ -0,0 +1,678 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// jobControl holds the terminal bookkeeping of an interactive shell. It stays
+// disabled when stdin is not a terminal, in which case children simply share
+// the shell's process group.
+var jobControl struct {
+	enabled bool
+	ttyFd   int
+	pgid    int // the shell's own process group
+}
+
+// InitJobControl puts the shell in its own process group, takes over the
+// terminal and starts tracking children. It is a no-op unless stdin is a tty.
+func InitJobControl() {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return
+	}
+
+	// If we were started in the background, wait until we are brought to
+	// the foreground before touching the terminal
+	for {
+		pgrp, err := unix.IoctlGetInt(fd, unix.TIOCGPGRP)
+		if err != nil {
+			return
+		}
+		if pgrp == syscall.Getpgrp() {
+			break
+		}
+		syscall.Kill(-syscall.Getpgrp(), syscall.SIGTTIN)
+	}
+
+	// Ctrl-Z and background reads are meant for our jobs, never for us. The
+	// signals are caught rather than ignored so that children still start
+	// with the default behaviour.
+	stops := make(chan os.Signal, 1)
+	signal.Notify(stops, syscall.SIGTSTP, syscall.SIGTTIN)
+	go func() {
+		for range stops {
+		}
+	}()
+
+	// Fails harmlessly when we already lead a session
+	syscall.Setpgid(0, 0)
+	jobControl.pgid = syscall.Getpgrp()
+	jobControl.ttyFd = fd
+	setForeground(jobControl.pgid)
+	jobControl.enabled = true
+
+	jobs.startReaper()
+}
+
+// setForeground hands the terminal to process group pgid. SIGTTOU is ignored
+// for the duration, since the call is made while we may be in the background.
+func setForeground(pgid int) error {
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	return unix.IoctlSetPointerInt(jobControl.ttyFd, unix.TIOCSPGRP, pgid)
+}
+
+// leaderName is the argv[0] of the process lead starts
+const leaderName = "traSH-job"
+
+func init() {
+	// A job leader is this program started again, and only has to exist
+	// until it is killed
+	if os.Args[0] == leaderName {
+		for {
+			time.Sleep(time.Hour)
+		}
+	}
+}
+
+// process is one stage of a job, either a child process or a builtin
+// running on its own goroutine
+type process struct {
+	pid     int // 0 for builtins
+	proc    *os.Process
+	result  chan int // delivers a builtin's status
+	status  int
+	signal  syscall.Signal // what killed or stopped it
+	done    bool
+	stopped bool
+	// gone is closed once a job leader has been waited for
+	gone chan struct{}
+}
+
+// finish records the wait status of a child that exited or was killed
+func (p *process) finish(ws syscall.WaitStatus) {
+	p.done, p.stopped = true, false
+	if ws.Signaled() {
+		p.status = 128 + int(ws.Signal())
+		p.signal = ws.Signal()
+	} else {
+		p.status = ws.ExitStatus()
+	}
+	if p.proc != nil {
+		p.proc.Release()
+	}
+	if p.gone != nil {
+		close(p.gone)
+	}
+}
+
+type jobState int
+
+const (
+	jobRunning jobState = iota
+	jobStopped
+	jobDone
+)
+
+func (s jobState) String() string {
+	switch s {
+	case jobStopped:
+		return "Stopped"
+	case jobDone:
+		return "Done"
+	default:
+		return "Running"
+	}
+}
+
+// Job is a pipeline the shell keeps track of, started in the foreground or
+// in the background
+type Job struct {
+	id         int
+	pgid       int // 0 when job control is off or no child was started
+	text       string
+	foreground bool // may take over the terminal
+	procs      []*process
+	started    time.Time
+
+	// leader stands in for a job that runs on goroutines, see lead, and
+	// stages counts those goroutines until they are done
+	leader *process
+	stages sync.WaitGroup
+	// grouped is set on the jobs of a subshell run for a job with a
+	// leader, which join the leader's process group
+	grouped bool
+
+	// waiting is set while the shell itself waits for the job, which keeps
+	// the SIGCHLD reaper away from its processes
+	waiting bool
+	// notified is set once the user has been told about the current state
+	notified bool
+	// tmodes holds the job's terminal modes while it is stopped
+	tmodes *term.State
+}
+
+func newJob(text string, foreground bool) *Job {
+	return &Job{text: text, foreground: foreground, started: time.Now()}
+}
+
+func (j *Job) state() jobState {
+	state := jobDone
+	for _, p := range j.procs {
+		if p.stopped {
+			return jobStopped
+		}
+		if !p.done {
+			state = jobRunning
+		}
+	}
+	return state
+}
+
+// status is the exit status of the job, which is that of its last process
+func (j *Job) status() int {
+	if len(j.procs) == 0 {
+		return 0
+	}
+	last := j.procs[len(j.procs)-1]
+	if last.stopped {
+		return 128 + int(syscall.SIGTSTP)
+	}
+	return last.status
+}
+
+// result describes how j finished, going by its last process
+func (j *Job) result() Result {
+	r := Result{Code: j.status(), Duration: time.Since(j.started)}
+	if n := len(j.procs); n > 0 {
+		if last := j.procs[n-1]; last.stopped {
+			r.Signal = syscall.SIGTSTP
+		} else {
+			r.Signal = last.signal
+		}
+	}
+	return r
+}
+
+// pipefailResult is what result is under set -o pipefail: how the last
+// process that failed finished, or success if none did
+func (j *Job) pipefailResult() Result {
+	r := j.result()
+	for i := len(j.procs) - 1; i >= 0; i-- {
+		switch p := j.procs[i]; {
+		case p.stopped:
+			r.Code, r.Signal = 128+int(syscall.SIGTSTP), syscall.SIGTSTP
+			return r
+		case p.status != 0:
+			r.Code, r.Signal = p.status, p.signal
+			return r
+		}
+	}
+	return r
+}
+
+// pipeStatus returns the status of every process of j, in pipeline order
+func (j *Job) pipeStatus() []int {
+	statuses := make([]int, len(j.procs))
+	for i, p := range j.procs {
+		if p.stopped {
+			statuses[i] = 128 + int(syscall.SIGTSTP)
+		} else {
+			statuses[i] = p.status
+		}
+	}
+	return statuses
+}
+
+// sysProcAttr returns the process attributes a new child of j starts with
+func (j *Job) sysProcAttr() *syscall.SysProcAttr {
+	if !jobControl.enabled {
+		return nil
+	}
+	return &syscall.SysProcAttr{
+		Setpgid:    true,
+		Pgid:       j.pgid,
+		Foreground: j.foreground && j.pgid == 0,
+		Ctty:       jobControl.ttyFd,
+	}
+}
+
+// addProcess records a child that was started for j; the first one decides
+// the job's process group
+func (j *Job) addProcess(proc *os.Process) {
+	if jobControl.enabled && j.pgid == 0 {
+		j.pgid = proc.Pid
+	}
+	j.procs = append(j.procs, &process{pid: proc.Pid, proc: proc})
+}
+
+// addFailed records a stage that could not even be started
+func (j *Job) addFailed(status int) {
+	j.procs = append(j.procs, &process{status: status, done: true})
+}
+
+// addBuiltin runs fn on its own goroutine as a stage of j
+func (j *Job) addBuiltin(fn func() int) {
+	p := &process{result: make(chan int, 1)}
+	j.procs = append(j.procs, p)
+	j.stages.Add(1)
+	go func() {
+		p.result <- fn()
+		j.stages.Done()
+	}()
+}
+
+// lead starts a process to lead j, a background job that the shell runs on
+// goroutines rather than in a child of its own. The leader only waits to be
+// killed, but it gives the job a pid for $! and a process group for what
+// its subshells start to join, so that fg, kill and Ctrl-C reach them as
+// they would a forked subshell. Without it the job simply has no pid.
+func (j *Job) lead() {
+	exe, err := os.Executable()
+	if err != nil {
+		return
+	}
+	proc, err := os.StartProcess(exe, []string{leaderName}, &os.ProcAttr{Sys: j.sysProcAttr()})
+	if err != nil {
+		return
+	}
+	j.addProcess(proc)
+	j.leader = j.procs[len(j.procs)-1]
+	j.leader.gone = make(chan struct{})
+}
+
+// retire kills the leader of j once the goroutines of j are all done, as
+// nothing is left to join its group then
+func (j *Job) retire() {
+	if j.leader == nil {
+		return
+	}
+	go func() {
+		j.stages.Wait()
+		j.leader.proc.Signal(syscall.SIGKILL)
+	}()
+}
+
+// killed reports whether the leader of j is gone while the job still runs,
+// which means something killed it, and if so with what status
+func (j *Job) killed() (int, bool) {
+	if j.leader == nil {
+		return 0, false
+	}
+	select {
+	case <-j.leader.gone:
+		return j.leader.status, true
+	default:
+		return 0, false
+	}
+}
+
+// signal delivers sig to every process of j
+func (j *Job) signal(sig syscall.Signal) {
+	if j.pgid > 0 {
+		syscall.Kill(-j.pgid, sig)
+		return
+	}
+	for _, p := range j.procs {
+		if p.pid > 0 && !p.done {
+			syscall.Kill(p.pid, sig)
+		}
+	}
+}
+
+// wait blocks until every process of j has finished or one of them stopped
+func (j *Job) wait() {
+	stopped := false
+	for _, p := range j.procs {
+		if p.done || p.pid == 0 {
+			continue
+		}
+		for {
+			var ws syscall.WaitStatus
+			_, err := syscall.Wait4(p.pid, &ws, syscall.WUNTRACED, nil)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				// Someone else reaped it; nothing more to learn
+				p.done = true
+			} else if ws.Stopped() {
+				if j.grouped {
+					// The job this one runs for was stopped; carry on
+					// once it is continued
+					continue
+				}
+				p.stopped, stopped = true, true
+			} else {
+				p.finish(ws)
+			}
+			break
+		}
+	}
+	if stopped {
+		// Builtins may be blocked on a stopped neighbour, don't wait for them
+		return
+	}
+
+	for _, p := range j.procs {
+		if p.pid == 0 && !p.done {
+			p.status, p.done = <-p.result, true
+		}
+	}
+}
+
+// waitForeground waits for j while it owns the terminal, then takes the
+// terminal back. A job that gets stopped is added to the job table.
+func (j *Job) waitForeground() int {
+	var shellModes *term.State
+	if jobControl.enabled {
+		shellModes, _ = term.GetState(jobControl.ttyFd)
+	}
+
+	jobs.mu.Lock()
+	jobs.fg = j
+	jobs.mu.Unlock()
+
+	j.wait()
+
+	jobs.mu.Lock()
+	jobs.fg = nil
+	jobs.mu.Unlock()
+
+	if jobControl.enabled && j.pgid > 0 {
+		if j.state() == jobStopped {
+			j.tmodes, _ = term.GetState(jobControl.ttyFd)
+		}
+		setForeground(jobControl.pgid)
+		if shellModes != nil {
+			term.Restore(jobControl.ttyFd, shellModes)
+		}
+	}
+
+	jobs.mu.Lock()
+	j.waiting = false
+	jobs.mu.Unlock()
+
+	if j.state() == jobStopped {
+		jobs.setCurrent(jobs.add(j))
+		jobs.mu.Lock()
+		j.notified = true
+		fmt.Fprintf(os.Stderr, "\n%s\n", jobs.format(j, false))
+		jobs.mu.Unlock()
+	} else {
+		jobs.remove(j)
+		if j.status() == 128+int(syscall.SIGINT) {
+			// The terminal echoed ^C but left the cursor on that line
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+	return j.status()
+}
+
+// jobTable holds the jobs the shell still knows about
+type jobTable struct {
+	mu   sync.Mutex
+	jobs []*Job
+	// order lists job ids from least to most recently used; the last one is
+	// the current job (%+) and the one before it the previous job (%-)
+	order []int
+	once  sync.Once
+	// fg is the job the shell is waiting for in the foreground, if any
+	fg *Job
+}
+
+var jobs = &jobTable{}
+
+// startReaper collects children of background jobs as soon as they change
+// state, so they never linger as zombies
+func (t *jobTable) startReaper() {
+	t.once.Do(func() {
+		sigchld := make(chan os.Signal, 1)
+		signal.Notify(sigchld, syscall.SIGCHLD)
+		go func() {
+			for range sigchld {
+				t.reap()
+			}
+		}()
+	})
+}
+
+// reap polls every process of every job nobody is waiting for
+func (t *jobTable) reap() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, j := range t.jobs {
+		if j.waiting {
+			continue
+		}
+		before := j.state()
+		for _, p := range j.procs {
+			if p.done {
+				continue
+			}
+			if p.pid == 0 {
+				select {
+				case p.status = <-p.result:
+					p.done = true
+				default:
+				}
+				continue
+			}
+
+			var ws syscall.WaitStatus
+			pid, err := syscall.Wait4(p.pid, &ws, syscall.WNOHANG|syscall.WUNTRACED|syscall.WCONTINUED, nil)
+			switch {
+			case err == syscall.ECHILD:
+				p.done = true
+			case err != nil || pid != p.pid:
+			case ws.Stopped():
+				p.stopped = true
+			case ws.Continued():
+				p.stopped = false
+			default:
+				p.finish(ws)
+			}
+		}
+		if j.state() != before {
+			j.notified = false
+		}
+	}
+}
+
+// add puts j in the table under a fresh id and returns that id
+func (t *jobTable) add(j *Job) int {
+	t.startReaper()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if j.id != 0 {
+		return j.id
+	}
+	id := 1
+	for _, other := range t.jobs {
+		if other.id >= id {
+			id = other.id + 1
+		}
+	}
+	j.id = id
+	t.jobs = append(t.jobs, j)
+	return id
+}
+
+func (t *jobTable) remove(j *Job) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, other := range t.jobs {
+		if other == j {
+			t.jobs = append(t.jobs[:i], t.jobs[i+1:]...)
+			break
+		}
+	}
+	t.forget(j.id)
+}
+
+// forget drops id from the current/previous job order. The caller must hold
+// t.mu.
+func (t *jobTable) forget(id int) {
+	for i, other := range t.order {
+		if other == id {
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// setCurrent makes job id the current job
+func (t *jobTable) setCurrent(id int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.forget(id)
+	t.order = append(t.order, id)
+}
+
+// mark returns '+' for the current job, '-' for the previous one and ' '
+// otherwise. The caller must hold t.mu.
+func (t *jobTable) mark(id int) byte {
+	n := len(t.order)
+	switch {
+	case n > 0 && t.order[n-1] == id:
+		return '+'
+	case n > 1 && t.order[n-2] == id:
+		return '-'
+	}
+	return ' '
+}
+
+// format renders j the way `jobs` lists it. The caller must hold t.mu.
+func (t *jobTable) format(j *Job, long bool) string {
+	state := j.state().String()
+	if j.state() == jobDone && j.status() != 0 {
+		state = "Exit " + strconv.Itoa(j.status())
+	}
+
+	text := j.text
+	if j.state() == jobRunning {
+		text += " &"
+	}
+	if long && j.pgid > 0 {
+		return fmt.Sprintf("[%d]%c %d %-24s%s", j.id, t.mark(j.id), j.pgid, state, text)
+	}
+	return fmt.Sprintf("[%d]%c  %-24s%s", j.id, t.mark(j.id), state, text)
+}
+
+// report writes a line for every job whose state changed since the user was
+// last told about it, or for every job when all is set. Finished jobs are
+// dropped from the table once reported.
+func (t *jobTable) report(w io.Writer, all, long bool) {
+	t.reap()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sort.Slice(t.jobs, func(a, b int) bool { return t.jobs[a].id < t.jobs[b].id })
+	var kept []*Job
+	for _, j := range t.jobs {
+		if all || !j.notified {
+			fmt.Fprintln(w, t.format(j, long))
+			j.notified = true
+		}
+		if j.state() == jobDone {
+			t.forget(j.id)
+		} else {
+			kept = append(kept, j)
+		}
+	}
+	t.jobs = kept
+}
+
+// NotifyJobs tells the user about background jobs that finished or stopped
+// since the last prompt
+func NotifyJobs() {
+	jobs.report(os.Stderr, false, false)
+}
+
+// byPid returns the job one of whose processes has the given pid
+func (t *jobTable) byPid(pid int) *Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, j := range t.jobs {
+		for _, p := range j.procs {
+			if p.pid == pid {
+				return j
+			}
+		}
+	}
+	return nil
+}
+
+// findJob resolves a job spec such as %1, %+, %-, %vim or %?make
+func (t *jobTable) findJob(spec string) (*Job, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	byID := func(id int) *Job {
+		for _, j := range t.jobs {
+			if j.id == id {
+				return j
+			}
+		}
+		return nil
+	}
+
+	var found *Job
+	switch s := strings.TrimPrefix(spec, "%"); {
+	case s == "" || s == "+" || s == "%":
+		if n := len(t.order); n > 0 {
+			found = byID(t.order[n-1])
+		}
+		if found == nil {
+			return nil, fmt.Errorf("%s: no current job", spec)
+		}
+		return found, nil
+	case s == "-":
+		if n := len(t.order); n > 1 {
+			found = byID(t.order[n-2])
+		}
+	case isDigits(s):
+		id, _ := strconv.Atoi(s)
+		found = byID(id)
+	default:
+		for _, j := range t.jobs {
+			var match bool
+			if strings.HasPrefix(s, "?") {
+				match = strings.Contains(j.text, s[1:])
+			} else {
+				match = strings.HasPrefix(j.text, s)
+			}
+			if !match {
+				continue
+			}
+			if found != nil {
+				return nil, fmt.Errorf("%s: ambiguous job spec", spec)
+			}
+			found = j
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	return found, nil
+}
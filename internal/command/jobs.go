@@ -0,0 +1,160 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// HandleJobs lists the jobs of the shell; -l adds process group ids and -p
+// prints nothing but them
+func HandleJobs(cmd *Command) error {
+	if cmd.HasOpt('p') {
+		jobs.reap()
+		jobs.mu.Lock()
+		defer jobs.mu.Unlock()
+		for _, j := range jobs.jobs {
+			for _, p := range j.procs {
+				if p.pid > 0 {
+					fmt.Fprintln(cmd.out(), p.pid)
+					break
+				}
+			}
+		}
+		return nil
+	}
+
+	jobs.report(cmd.out(), true, cmd.HasOpt('l'))
+	return nil
+}
+
+// jobArg resolves the first job spec of cmd, defaulting to the current job
+func jobArg(cmd *Command) (*Job, error) {
+	spec := "%+"
+	for _, arg := range cmd.args {
+		if len(arg) > 0 && arg[0] != '-' {
+			spec = arg
+			break
+		}
+	}
+	return jobs.findJob(spec)
+}
+
+// HandleFg brings a job to the foreground, continuing it if it was stopped
+func HandleFg(cmd *Command) error {
+	if !jobControl.enabled {
+		return fmt.Errorf("no job control")
+	}
+	j, err := jobArg(cmd)
+	if err != nil {
+		return err
+	}
+
+	jobs.mu.Lock()
+	j.waiting = true
+	j.foreground = true
+	for _, p := range j.procs {
+		p.stopped = false
+	}
+	jobs.mu.Unlock()
+
+	fmt.Fprintln(cmd.out(), j.text)
+	if j.pgid > 0 {
+		if j.tmodes != nil {
+			term.Restore(jobControl.ttyFd, j.tmodes)
+		}
+		setForeground(j.pgid)
+	}
+	j.signal(syscall.SIGCONT)
+
+	return ExitStatus(j.waitForeground())
+}
+
+// HandleBg continues a stopped job in the background
+func HandleBg(cmd *Command) error {
+	if !jobControl.enabled {
+		return fmt.Errorf("no job control")
+	}
+	j, err := jobArg(cmd)
+	if err != nil {
+		return err
+	}
+
+	jobs.mu.Lock()
+	state := j.state()
+	if state == jobStopped {
+		for _, p := range j.procs {
+			p.stopped = false
+		}
+		j.foreground = false
+		j.notified = true
+	}
+	jobs.mu.Unlock()
+
+	if state != jobStopped {
+		return fmt.Errorf("job %d already in background", j.id)
+	}
+	j.signal(syscall.SIGCONT)
+	jobs.setCurrent(j.id)
+	fmt.Fprintf(cmd.out(), "[%d]+ %s &\n", j.id, j.text)
+	return nil
+}
+
+// HandleWait waits for the given jobs or process ids, or for every
+// background job when called without arguments. Its status is the status of
+// the last job named, and 0 without arguments.
+func HandleWait(cmd *Command) error {
+	var targets []*Job
+	if len(cmd.args) == 0 {
+		jobs.mu.Lock()
+		targets = append(targets, jobs.jobs...)
+		jobs.mu.Unlock()
+	}
+
+	status := 0
+	for _, arg := range cmd.args {
+		if pid, err := strconv.Atoi(arg); err == nil {
+			j := jobs.byPid(pid)
+			if j == nil {
+				fmt.Fprintf(cmd.errOut(), "traSH: wait: pid %d is not a child of this shell\n", pid)
+				status = 127
+				continue
+			}
+			targets = append(targets, j)
+			continue
+		}
+		j, err := jobs.findJob(arg)
+		if err != nil {
+			fmt.Fprintf(cmd.errOut(), "traSH: wait: %v\n", err)
+			status = 127
+			continue
+		}
+		targets = append(targets, j)
+	}
+
+	for _, j := range targets {
+		jobs.mu.Lock()
+		j.waiting = true
+		jobs.mu.Unlock()
+
+		j.wait()
+
+		jobs.mu.Lock()
+		j.waiting = false
+		if len(cmd.args) > 0 {
+			status = j.status()
+		}
+		done := j.state() == jobDone
+		jobs.mu.Unlock()
+		if done {
+			jobs.remove(j)
+		}
+	}
+
+	if status != 0 {
+		return ExitStatus(status)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package command
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mush1e/traSH/internal/parser"
+)
+
+// RunList runs the items of l in order. It returns the status of the last
+// pipeline that ran, and ErrExit if the shell should terminate. A break or
+// continue also stops the list, returning its error for the loop to handle.
+func (sh *Shell) RunList(l *parser.List) (int, error) {
+	mode := execForeground
+	if sh.async {
+		mode = execAsync
+	}
+
+	status := 0
+	for _, a := range l.Items {
+		var err error
+		if a.Background {
+			status, err = sh.runBackground(a)
+		} else {
+			status, err = sh.runAndOr(a, mode)
+		}
+		if err != nil {
+			return status, err
+		}
+		if sh.interrupted() {
+			// Ctrl-C stops the rest of the list along with the command
+			return status, nil
+		}
+	}
+	return status, nil
+}
+
+// runAndOr runs the pipelines of a, skipping the right-hand side of "&&"
+// after a failure and of "||" after a success
+func (sh *Shell) runAndOr(a *parser.AndOr, mode execMode) (int, error) {
+	status := 0
+	for i, p := range a.Pipelines {
+		if i > 0 {
+			switch a.Ops[i-1] {
+			case "&&":
+				if status != 0 {
+					continue
+				}
+			case "||":
+				if status == 0 {
+					continue
+				}
+			}
+		}
+
+		// Only the last pipeline's status is the status of the whole
+		last := i == len(a.Pipelines)-1
+		if !last {
+			sh.condition++
+		}
+		var err error
+		status, err = sh.runPipeline(p, mode)
+		if !last {
+			sh.condition--
+		}
+		if err != nil || sh.interrupted() {
+			return status, err
+		}
+	}
+	return status, nil
+}
+
+// runBackground starts a as a background job. A single pipeline becomes a
+// regular job; a longer and-or list is driven from its own goroutine, which
+// waits for each of its pipelines in turn.
+func (sh *Shell) runBackground(a *parser.AndOr) (int, error) {
+	if len(a.Pipelines) == 1 {
+		return sh.runPipeline(a.Pipelines[0], execBackground)
+	}
+
+	// Like any background job the list runs in a subshell, which also keeps
+	// it from racing the shell over $? and friends
+	sub := sh.subshell()
+	sub.async = true
+	job := newJob(a.String(), false)
+	job.lead()
+	if job.leader != nil {
+		sub.group = job
+		sh.lastBgPid = job.leader.pid
+	}
+	job.addBuiltin(func() int {
+		status, _ := sub.runAndOr(a, execAsync)
+		return sub.RunExitTrap(status)
+	})
+	job.retire()
+	job.notified = true
+	id := jobs.add(job)
+	jobs.setCurrent(id)
+	if jobControl.enabled {
+		fmt.Fprintf(os.Stderr, "[%d] %d\n", id, job.pgid)
+	}
+	return 0, nil
+}
@@ -0,0 +1,52 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// loopControl is returned by break and continue to unwind the loops they
+// are in
+type loopControl struct {
+	cont  bool // continue rather than break
+	depth int  // how many enclosing loops it applies to
+}
+
+func (lc *loopControl) Error() string {
+	if lc.cont {
+		return "continue"
+	}
+	return "break"
+}
+
+// HandleBreak leaves the innermost loop, or the innermost n loops
+func HandleBreak(cmd *Command) error {
+	return loopBuiltin(cmd, false)
+}
+
+// HandleContinue skips to the next iteration of the innermost loop, or of
+// the nth loop out
+func HandleContinue(cmd *Command) error {
+	return loopBuiltin(cmd, true)
+}
+
+func loopBuiltin(cmd *Command, cont bool) error {
+	sh := cmd.sh
+	if sh == nil || sh.loopDepth == 0 {
+		fmt.Fprintf(cmd.errOut(), "traSH: %s: only meaningful in a `for', `while', or `until' loop\n", cmd.command)
+		return nil
+	}
+
+	n := 1
+	if len(cmd.args) > 0 {
+		var err error
+		n, err = strconv.Atoi(cmd.args[0])
+		if err != nil {
+			return fmt.Errorf("%s: numeric argument required", cmd.args[0])
+		}
+		if n < 1 {
+			return fmt.Errorf("%d: loop count out of range", n)
+		}
+	}
+	return &loopControl{cont: cont, depth: min(n, sh.loopDepth)}
+}
@@ -0,0 +1,278 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/mush1e/traSH/internal/parser"
+)
+
+// execMode says how a pipeline relates to the terminal and to the caller
+type execMode int
+
+const (
+	// execForeground waits for the pipeline while it owns the terminal
+	execForeground execMode = iota
+	// execBackground starts the pipeline as a background job and returns
+	execBackground
+	// execAsync waits for the pipeline without ever giving it the terminal,
+	// as needed when running inside a background job
+	execAsync
+)
+
+// RunPipeline runs p in the foreground. See runPipeline.
+func (sh *Shell) RunPipeline(p *parser.Pipeline) (int, error) {
+	return sh.runPipeline(p, execForeground)
+}
+
+// runPipeline starts every stage of p at once, connecting them with OS pipes,
+// as a single job. Unless the pipeline goes to the background it then waits
+// for it; its status is the status of its last stage. Errors are reported on
+// stderr as they happen; the only errors returned are ErrExit, when a lone
+// `exit` asks the shell to terminate, and those of return, break and
+// continue.
+func (sh *Shell) runPipeline(p *parser.Pipeline, mode execMode) (int, error) {
+	sh.line = p.Pos.Line
+	if sh.group != nil {
+		if status, killed := sh.group.killed(); killed {
+			// A subshell dies with the job it runs for
+			return status, ErrExit
+		}
+	}
+	if mode != execBackground {
+		if status, err := sh.runDebugTrap(p); err != nil {
+			return status, err
+		}
+	}
+
+	status, err := sh.runCommands(p, mode)
+	if mode == execForeground && takeInterrupt() {
+		// Ctrl-C came while the shell ran this itself, which should stop
+		// any list or loop it is part of just as if a child had been killed
+		status = 128 + int(syscall.SIGINT)
+		sh.last.Code, sh.last.Signal = status, syscall.SIGINT
+	}
+	if p.Bang && mode != execBackground {
+		// ! only inverts $?, PIPESTATUS keeps the real statuses
+		if status == 0 {
+			status = 1
+		} else {
+			status = 0
+		}
+		sh.last.Code = status
+	}
+	if err != nil || mode == execBackground {
+		return status, err
+	}
+
+	// Traps run once the pipeline is done, which is as soon as is safe
+	if status != 0 {
+		if code, err := sh.runErrTrap(p); err != nil {
+			return code, err
+		}
+		if sh.exitsOnError(p) {
+			return status, ErrExit
+		}
+	}
+	if code, err := sh.runTraps(); err != nil {
+		return code, err
+	}
+	return status, nil
+}
+
+// runCommands does the work of runPipeline, leaving out the effect of "!"
+func (sh *Shell) runCommands(p *parser.Pipeline, mode execMode) (int, error) {
+	if len(p.Commands) == 0 {
+		return 0, nil
+	}
+	start := time.Now()
+
+	n := len(p.Commands)
+	cmds := make([]*Command, n)
+	sh.substStatus = 0
+	for i, cmd := range p.Commands {
+		// Compound commands are expanded bit by bit as they run
+		c, ok := cmd.(*parser.SimpleCommand)
+		if !ok {
+			continue
+		}
+		x, err := sh.expandCommand(c)
+		if err != nil {
+			sh.report(err)
+			sh.setResult(Result{Code: 1, Duration: time.Since(start)}, []int{1})
+			if fatalExpansion(err) && (sh.level > 0 || !isInteractive()) {
+				// A script cannot go on without the variable
+				return 1, ErrExit
+			}
+			return 1, nil
+		}
+		cmds[i] = x
+	}
+	if sh.options["xtrace"] {
+		for _, cmd := range cmds {
+			if cmd != nil {
+				sh.trace(cmd)
+			}
+		}
+	}
+
+	// A lone compound command runs in the shell too, so that variables it
+	// sets stay set, and so does a function definition
+	if cmds[0] == nil && n == 1 && mode != execBackground {
+		status, err := sh.runInShell(p.Commands[0])
+		r := Result{Code: status, Duration: time.Since(start)}
+		if sh.interrupted() {
+			// Let any loop this one is in stop as well
+			r.Signal = syscall.SIGINT
+		}
+		sh.setResult(r, []int{status})
+		return status, err
+	}
+
+	// A lone builtin runs right here in the shell, so that cd and exit work
+	if cmd := cmds[0]; n == 1 && mode != execBackground && (cmd.command == "" || sh.inProcess(cmd.command)) {
+		cmd.setStdio(sh.stdin, sh.stdout, sh.stderr)
+		status, err := HandleCommand(cmd)
+		if err != nil && !unwinding(err) {
+			sh.report(err)
+			err = nil
+		}
+		if cmd.command == "" && status == 0 {
+			// Assignments alone leave the status of their last substitution
+			status = sh.substStatus
+		}
+		sh.setResult(Result{Code: status, Duration: time.Since(start)}, []int{status})
+		return status, err
+	}
+
+	job := newJob(p.String(), mode == execForeground)
+	if mode == execBackground && slices.ContainsFunc(cmds, func(cmd *Command) bool {
+		return cmd == nil || sh.inProcess(cmd.command)
+	}) {
+		job.lead()
+	} else if g := sh.group; g != nil && mode != execBackground {
+		job.pgid, job.foreground, job.grouped = g.pgid, false, true
+	}
+
+	stdin := sh.stdin
+	if mode == execBackground && !jobControl.enabled {
+		// Without job control a background job must not compete with the
+		// shell for its input
+		if devNull, err := os.Open(os.DevNull); err == nil {
+			stdin = devNull
+		}
+	}
+
+	for i, cmd := range cmds {
+		var next *os.File
+		stdout := sh.stdout
+		if i < n-1 {
+			r, w, err := os.Pipe()
+			if err != nil {
+				fmt.Fprintf(sh.stderr, "traSH: pipe: %v\n", err)
+				sh.closeUnlessStd(stdin)
+				job.addFailed(1)
+				break
+			}
+			stdout, next = w, r
+		}
+
+		if cmd == nil {
+			job.addBuiltin(sh.runStage(p.Commands[i], stdin, stdout, job))
+			stdin = next
+			continue
+		}
+
+		cmd.setStdio(stdin, stdout, sh.stderr)
+		if sh.inProcess(cmd.command) {
+			// Like a compound command, a builtin or function in a pipeline
+			// or in the background runs in a subshell, so that cd or export
+			// there leaves the shell alone and stages never share its maps
+			sub := sh.subshell()
+			sub.async = true
+			if job.leader != nil {
+				sub.group = job
+			}
+			cmd.sh = sub
+		}
+
+		if sh.inProcess(cmd.command) {
+			// Builtins run in-process, so they own their pipe ends until done.
+			// Every stage runs in its own little world, so an exit inside a
+			// pipeline only ends that stage.
+			job.addBuiltin(func(cmd *Command, stdin, stdout *os.File) func() int {
+				return func() int {
+					status, err := HandleCommand(cmd)
+					if err != nil && !errors.Is(err, ErrExit) {
+						cmd.sh.report(err)
+					}
+					sh.closeUnlessStd(stdin)
+					sh.closeUnlessStd(stdout)
+					return status
+				}
+			}(cmd, stdin, stdout))
+		} else {
+			if n > 1 || mode == execBackground {
+				// Only a command the shell runs itself counts in its hash
+				// table, not one run for a pipeline or a background job
+				cmd.sh = sh.forked()
+			}
+			proc, err := startExternal(cmd, job)
+			// The child holds its own copies of the pipe ends now
+			sh.closeUnlessStd(stdin)
+			sh.closeUnlessStd(stdout)
+			switch {
+			case err != nil:
+				sh.report(err)
+				job.addFailed(statusOf(err))
+			case proc != nil:
+				job.addProcess(proc)
+			default:
+				job.addFailed(0)
+			}
+		}
+
+		stdin = next
+	}
+
+	job.retire()
+	if mode == execBackground {
+		if n := len(job.procs); n > 0 && job.procs[n-1].pid > 0 {
+			sh.lastBgPid = job.procs[n-1].pid
+		} else if job.leader != nil {
+			sh.lastBgPid = job.leader.pid
+		}
+		job.notified = true
+		id := jobs.add(job)
+		jobs.setCurrent(id)
+		if jobControl.enabled {
+			fmt.Fprintf(os.Stderr, "[%d] %d\n", id, job.pgid)
+		}
+		sh.setResult(Result{}, []int{0})
+		return 0, nil
+	}
+	if mode == execAsync {
+		job.wait()
+	} else {
+		job.waitForeground()
+	}
+	r := job.result()
+	if sh.options["pipefail"] {
+		r = job.pipefailResult()
+	}
+	sh.setResult(r, job.pipeStatus())
+	return r.Code, nil
+}
+
+// closeUnlessStd closes f unless it is one of the files the shell itself
+// reads from and writes to
+func (sh *Shell) closeUnlessStd(f *os.File) {
+	if f != nil && f != sh.stdin && f != sh.stdout && f != sh.stderr &&
+		f != os.Stdin && f != os.Stdout && f != os.Stderr {
+		f.Close()
+	}
+}
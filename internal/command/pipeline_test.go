@@ -0,0 +1,56 @@
+package command
+
+import (
+	"os"
+	"testing"
+)
+
+// runLine parses and runs line in sh
+func runLine(t *testing.T, sh *Shell, line string) {
+	t.Helper()
+	list, err := sh.Parse(line)
+	if err != nil {
+		t.Fatalf("parse %q: %v", line, err)
+	}
+	if _, err := sh.RunList(list); err != nil {
+		t.Fatalf("run %q: %v", line, err)
+	}
+}
+
+func TestPipelineBuiltinLeavesShellAlone(t *testing.T) {
+	sh := NewShell()
+	before, _ := os.Getwd()
+	dir := t.TempDir()
+
+	runLine(t, sh, "cd "+dir+" | cat")
+	runLine(t, sh, "cd "+dir+" & wait")
+
+	if wd, _ := os.Getwd(); wd != before {
+		t.Errorf("cd in a pipeline moved the shell to %s", wd)
+	}
+	if pwd, _ := sh.vars.Get("PWD"); pwd != before {
+		t.Errorf("cd in a pipeline set PWD to %s", pwd)
+	}
+}
+
+func TestPipelineBuiltinStagesRunConcurrently(t *testing.T) {
+	sh := NewShell()
+
+	runLine(t, sh, "alias a=1 | alias b=2 | alias c=3")
+	runLine(t, sh, "export TRASH_PIPE_A=1 | export TRASH_PIPE_B=2 | set -e")
+
+	if len(sh.aliases) != 0 {
+		t.Errorf("aliases defined in a pipeline leaked into the shell: %v", sh.aliases)
+	}
+	for _, name := range []string{"TRASH_PIPE_A", "TRASH_PIPE_B"} {
+		if _, ok := sh.vars.Get(name); ok {
+			t.Errorf("%s exported in a pipeline leaked into the shell", name)
+		}
+		if _, ok := os.LookupEnv(name); ok {
+			t.Errorf("%s exported in a pipeline leaked into the environment", name)
+		}
+	}
+	if sh.options["errexit"] {
+		t.Error("set -e in a pipeline turned on errexit in the shell")
+	}
+}
@@ -0,0 +1,356 @@
+package command
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mush1e/traSH/internal/parser"
+	"golang.org/x/sys/unix"
+)
+
+const readUsage = "usage: read [-rs] [-a array] [-d delim] [-n nchars] [-p prompt] [-t timeout] [name ...]"
+
+// readOptions are the options of the read builtin
+type readOptions struct {
+	raw     bool    // -r: backslashes are just characters
+	silent  bool    // -s: do not echo what is typed
+	prompt  string  // -p: shown on stderr when reading from a terminal
+	timeout float64 // -t: seconds to wait for input, negative for no limit
+	count   int     // -n: stop after this many characters, 0 for no limit
+	delim   byte    // -d: what ends the input instead of a newline
+	array   string  // -a: put the fields into this array
+}
+
+// readChar is a character read by read, with whether a backslash quoted
+// it, which keeps it from splitting fields
+type readChar struct {
+	r      rune
+	quoted bool
+}
+
+// HandleRead reads a line from standard input and splits it into fields
+// at the characters in IFS. Each name gets a field, and the last one the
+// rest of the line; without names the whole line goes to REPLY. The status
+// is 1 at the end of input, and above 128 on a timeout.
+func HandleRead(cmd *Command) error {
+	sh := cmd.sh
+	opts, names, err := parseReadOptions(cmd.args)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !parser.IsName(name) {
+			return fmt.Errorf("`%s': not a valid identifier", name)
+		}
+	}
+	if opts.array != "" && !parser.IsName(opts.array) {
+		return fmt.Errorf("`%s': not a valid identifier", opts.array)
+	}
+
+	in := cmd.in()
+	if in == nil {
+		return fmt.Errorf("read error: 0: bad file descriptor")
+	}
+	fd := int(in.Fd())
+
+	restore, isTerminal := terminalForRead(fd, opts)
+	defer restore()
+	if opts.prompt != "" && isTerminal {
+		fmt.Fprint(cmd.errOut(), opts.prompt)
+	}
+
+	chars, status := readInput(fd, opts)
+	if opts.silent && isTerminal && status == 0 {
+		// The newline that ended the input was not echoed either
+		fmt.Fprintln(cmd.errOut())
+	}
+	if status > 128 && status != 128+int(syscall.SIGALRM) {
+		// Interrupted: nothing is assigned
+		return ExitStatus(status)
+	}
+
+	ifs := sh.ifs()
+	switch {
+	case opts.array != "":
+		sh.vars.SetArray(opts.array, splitRead(chars, ifs, -1))
+	case len(names) == 0:
+		sh.vars.Set("REPLY", readString(chars))
+	default:
+		fields := splitRead(chars, ifs, len(names))
+		for i, name := range names {
+			value := ""
+			if i < len(fields) {
+				value = fields[i]
+			}
+			sh.vars.Set(name, value)
+		}
+	}
+	if status != 0 {
+		return ExitStatus(status)
+	}
+	return nil
+}
+
+// parseReadOptions separates the options of read from the names to assign
+func parseReadOptions(args []string) (readOptions, []string, error) {
+	opts := readOptions{timeout: -1, delim: '\n'}
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+
+	letters:
+		for i := 1; i < len(arg); i++ {
+			letter := arg[i]
+			switch letter {
+			case 'r':
+				opts.raw = true
+				continue
+			case 's':
+				opts.silent = true
+				continue
+			case 'p', 't', 'n', 'd', 'a':
+			default:
+				return opts, nil, fmt.Errorf("-%c: invalid option\n%s", letter, readUsage)
+			}
+
+			// The value is the rest of this word or else the next one
+			value := arg[i+1:]
+			if value == "" {
+				if len(args) == 0 {
+					return opts, nil, fmt.Errorf("-%c: option requires an argument\n%s", letter, readUsage)
+				}
+				value, args = args[0], args[1:]
+			}
+			switch letter {
+			case 'p':
+				opts.prompt = value
+			case 't':
+				t, err := strconv.ParseFloat(value, 64)
+				if err != nil || t < 0 || math.IsInf(t, 0) {
+					return opts, nil, fmt.Errorf("%s: invalid timeout specification", value)
+				}
+				opts.timeout = t
+			case 'n':
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					return opts, nil, fmt.Errorf("%s: invalid number", value)
+				}
+				opts.count = n
+			case 'd':
+				// An empty delimiter reads up to a NUL byte
+				opts.delim = 0
+				if value != "" {
+					opts.delim = value[0]
+				}
+			case 'a':
+				opts.array = value
+			}
+			break letters
+		}
+	}
+	return opts, args, nil
+}
+
+// terminalForRead puts the terminal on fd, if it is one, into the mode the
+// options ask for: no echo for -s, and a character at a time for -n. It
+// returns what undoes that, and whether fd is a terminal at all.
+func terminalForRead(fd int, opts readOptions) (func(), bool) {
+	old, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return func() {}, false
+	}
+	if !opts.silent && opts.count == 0 {
+		return func() {}, true
+	}
+
+	mode := *old
+	if opts.silent {
+		mode.Lflag &^= unix.ECHO
+	}
+	if opts.count > 0 {
+		mode.Lflag &^= unix.ICANON
+		mode.Cc[unix.VMIN] = 1
+		mode.Cc[unix.VTIME] = 0
+	}
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, &mode); err != nil {
+		return func() {}, true
+	}
+	return func() { unix.IoctlSetTermios(fd, ioctlSetTermios, old) }, true
+}
+
+// readInput reads from fd up to the delimiter, a byte at a time so that
+// whatever comes after is left for the next reader. Unless opts.raw is set
+// a backslash quotes the next character, and a backslash-newline pair is
+// dropped so that the input continues on the next line. The status is 1
+// when the input ends first, 128+SIGALRM on a timeout, and 128+N when
+// signal N interrupts it.
+func readInput(fd int, opts readOptions) ([]readChar, int) {
+	var deadline time.Time
+	if opts.timeout >= 0 {
+		deadline = time.Now().Add(time.Duration(opts.timeout * float64(time.Second)))
+	}
+
+	var chars []readChar
+	var pending []byte // the bytes of a character not yet complete
+	escaped := false
+	for opts.count == 0 || len(chars) < opts.count {
+		b, status := readByte(fd, deadline)
+		if status != 0 {
+			return chars, status
+		}
+
+		if len(pending) == 0 && !escaped {
+			if b == opts.delim {
+				return chars, 0
+			}
+			if b == '\\' && !opts.raw {
+				escaped = true
+				continue
+			}
+		}
+		if escaped && b == '\n' && len(pending) == 0 {
+			escaped = false
+			continue
+		}
+
+		pending = append(pending, b)
+		if !utf8.FullRune(pending) {
+			continue
+		}
+		r, _ := utf8.DecodeRune(pending)
+		chars = append(chars, readChar{r: r, quoted: escaped})
+		pending, escaped = pending[:0], false
+	}
+	return chars, 0
+}
+
+// readByte reads a single byte from fd, giving up at deadline unless it is
+// zero. Waiting is done in short slices, so that Ctrl-C and trapped
+// signals get through.
+func readByte(fd int, deadline time.Time) (byte, int) {
+	for {
+		if interruptPending.Load() {
+			return 0, 128 + int(syscall.SIGINT)
+		}
+		if sig, ok := catcher.firstPending(); ok {
+			return 0, 128 + int(sig)
+		}
+
+		wait := 100 * time.Millisecond
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return 0, 128 + int(syscall.SIGALRM)
+			}
+			wait = min(wait, left)
+		}
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, int(wait.Milliseconds()))
+		if err != nil && err != unix.EINTR {
+			return 0, 1
+		}
+		if n == 0 {
+			continue
+		}
+
+		var buf [1]byte
+		n, err = unix.Read(fd, buf[:])
+		switch {
+		case err == unix.EINTR || err == unix.EAGAIN:
+			continue
+		case n == 1:
+			return buf[0], 0
+		default:
+			return 0, 1
+		}
+	}
+}
+
+// readString turns what read read back into a string
+func readString(chars []readChar) string {
+	var sb strings.Builder
+	for _, c := range chars {
+		sb.WriteRune(c.r)
+	}
+	return sb.String()
+}
+
+// splitRead splits chars into fields at unquoted characters of ifs the way
+// read does. With n above zero there are at most n fields, the last being
+// the rest of the line.
+func splitRead(chars []readChar, ifs string, n int) []string {
+	isSep := func(c readChar) bool {
+		return !c.quoted && strings.ContainsRune(ifs, c.r)
+	}
+	isSpace := func(c readChar) bool {
+		return isSep(c) && (c.r == ' ' || c.r == '\t' || c.r == '\n')
+	}
+
+	// Whitespace in IFS at either end does not count
+	start, end := 0, len(chars)
+	for start < end && isSpace(chars[start]) {
+		start++
+	}
+	for end > start && isSpace(chars[end-1]) {
+		end--
+	}
+	chars = chars[start:end]
+
+	var fields []string
+	for len(chars) > 0 {
+		if n > 0 && len(fields) == n-1 {
+			// The last name gets the rest, less a lone separator at its
+			// end
+			rest := chars
+			if k := len(rest); isSep(rest[k-1]) && !isSpace(rest[k-1]) {
+				field := rest[:k-1]
+				for len(field) > 0 && isSpace(field[len(field)-1]) {
+					field = field[:len(field)-1]
+				}
+				if !containsSep(field, isSep) {
+					rest = field
+				}
+			}
+			fields = append(fields, readString(rest))
+			break
+		}
+
+		i := 0
+		for i < len(chars) && !isSep(chars[i]) {
+			i++
+		}
+		fields = append(fields, readString(chars[:i]))
+
+		// A separator is any run of whitespace, with at most one other
+		// IFS character in it
+		for i < len(chars) && isSpace(chars[i]) {
+			i++
+		}
+		if i < len(chars) && isSep(chars[i]) && !isSpace(chars[i]) {
+			i++
+			for i < len(chars) && isSpace(chars[i]) {
+				i++
+			}
+		}
+		chars = chars[i:]
+	}
+	return fields
+}
+
+// containsSep reports whether any of chars is a separator
+func containsSep(chars []readChar, isSep func(readChar) bool) bool {
+	for _, c := range chars {
+		if isSep(c) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// redirect is a single I/O redirection attached to a command
+type redirect struct {
+	fd     int    // descriptor being redirected, -1 for &> which targets both 1 and 2
+	op     string // one of < > >> >| <& >& &> &>>
+	target string // file name, or descriptor number / "-" for <& and >&
+}
+
+func (r redirect) String() string {
+	switch {
+	case r.fd < 0:
+		return r.op + r.target
+	case (r.fd == 0 && r.op[0] == '<') || (r.fd == 1 && r.op[0] == '>'):
+		return r.op + r.target
+	default:
+		return strconv.Itoa(r.fd) + r.op + r.target
+	}
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// applyRedirects performs cmd's redirections left to right on top of its
+// current file table, so that `>out 2>&1` and `2>&1 >out` differ the same
+// way they do in any POSIX shell. The returned function closes every file
+// that was opened along the way and must be called once cmd is done with
+// them (or once a child process has inherited them).
+func applyRedirects(cmd *Command) (func(), error) {
+	var opened []*os.File
+	cleanup := func() {
+		for _, f := range opened {
+			f.Close()
+		}
+	}
+	if len(cmd.redirs) == 0 {
+		return cleanup, nil
+	}
+
+	files := []*os.File{cmd.in(), cmd.out(), cmd.errOut()}
+	if len(cmd.files) > 3 {
+		files = append(files, cmd.files[3:]...)
+	}
+	setFile := func(fd int, f *os.File) {
+		for len(files) <= fd {
+			files = append(files, nil)
+		}
+		files[fd] = f
+	}
+
+	for _, r := range cmd.redirs {
+		switch r.op {
+		case "<&", ">&":
+			if r.target == "-" {
+				setFile(r.fd, nil)
+				continue
+			}
+			src, err := strconv.Atoi(r.target)
+			if err != nil {
+				cleanup()
+				return nil, fmt.Errorf("traSH: %s: ambiguous redirect", r.target)
+			}
+			if src >= len(files) || files[src] == nil {
+				cleanup()
+				return nil, fmt.Errorf("traSH: %d: bad file descriptor", src)
+			}
+			setFile(r.fd, files[src])
+
+		default:
+			noclobber := cmd.sh != nil && cmd.sh.options["noclobber"]
+			f, err := openRedirect(r, cmd.path(r.target), noclobber)
+			if err != nil {
+				cleanup()
+				return nil, err
+			}
+			opened = append(opened, f)
+			if r.fd < 0 {
+				setFile(1, f)
+				setFile(2, f)
+			} else {
+				setFile(r.fd, f)
+			}
+		}
+	}
+
+	cmd.files = files
+	return cleanup, nil
+}
+
+// openRedirect opens the target file of r, found at path, with the flags
+// its operator implies. With noclobber set, > and &> refuse to truncate an
+// existing file; >| always does.
+func openRedirect(r redirect, path string, noclobber bool) (*os.File, error) {
+	if r.target == "" {
+		return nil, fmt.Errorf("traSH: ambiguous redirect")
+	}
+
+	var flag int
+	switch r.op {
+	case "<":
+		flag = os.O_RDONLY
+	case ">", "&>":
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if noclobber {
+			info, err := os.Stat(path)
+			switch {
+			case err == nil && info.Mode().IsRegular():
+				return nil, fmt.Errorf("traSH: %s: cannot overwrite existing file", r.target)
+			case errors.Is(err, os.ErrNotExist):
+				// Create it, without overwriting one that appears meanwhile
+				flag |= os.O_EXCL
+			}
+			// Anything else, such as /dev/null, is written to as usual
+		}
+	case ">|":
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case ">>", "&>>":
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		if errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("traSH: %s: cannot overwrite existing file", r.target)
+		}
+		return nil, fmt.Errorf("traSH: %s: %v", r.target, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,217 @@
+package command
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mush1e/traSH/internal/parser"
+)
+
+// lineReader is where the lines of a script come from
+type lineReader interface {
+	ReadString(delim byte) (string, error)
+}
+
+// unbufferedReader reads a byte at a time. Commands in a script read from
+// stdin share it with the shell, so the shell must never read past the end
+// of the command it is about to run.
+type unbufferedReader struct {
+	f *os.File
+}
+
+func (u unbufferedReader) ReadString(delim byte) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := u.f.Read(b)
+		if n == 1 {
+			line = append(line, b[0])
+			if b[0] == delim {
+				return string(line), nil
+			}
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
+
+// RunScript runs the commands read from r until the input ends or exit is
+// run, and returns the status the shell should exit with. Each command runs
+// as soon as it is complete, so a script may define what its later lines
+// use. A syntax error stops the script with status 2. name is the script
+// file named in error messages, empty for standard input.
+func (sh *Shell) RunScript(r io.Reader, name string) int {
+	var lines lineReader
+	if f, ok := r.(*os.File); ok && f == os.Stdin {
+		lines = unbufferedReader{f}
+	} else {
+		lines = bufio.NewReader(r)
+	}
+
+	status, _ := sh.runSource(lines, name)
+	return sh.RunExitTrap(status)
+}
+
+// runSource runs the commands read from lines in the shell until the
+// input ends, a syntax error stops it, or exit, return, break or continue
+// unwind out of it. Errors are reported along with name and the line they
+// come from.
+func (sh *Shell) runSource(lines lineReader, name string) (int, error) {
+	prefix := "traSH: "
+	if name != "" {
+		prefix += name + ": "
+	}
+	source, line := sh.source, sh.line
+	sh.source = name
+	defer func() { sh.source, sh.line = source, line }()
+
+	status, lineNo := 0, 0
+	for {
+		// Read lines until they make up a complete command
+		var src string
+		start := lineNo + 1
+		var list *parser.List
+		var err error
+		for {
+			line, readErr := lines.ReadString('\n')
+			if line != "" {
+				lineNo++
+			}
+			src += line
+			list, err = sh.parseAt(src, start)
+			if readErr != nil {
+				if readErr != io.EOF {
+					fmt.Fprintf(sh.stderr, "%s%v\n", prefix, readErr)
+					return 1, nil
+				}
+				if src == "" {
+					return status, nil
+				}
+				if errors.Is(err, parser.ErrIncomplete) && strings.HasSuffix(src, "\\\n") {
+					// A line continuation at the very end continues nothing
+					list, err = sh.parseAt(strings.TrimSuffix(src, "\\\n"), start)
+				}
+				break
+			}
+			if !errors.Is(err, parser.ErrIncomplete) {
+				break
+			}
+		}
+
+		if err != nil {
+			if pos, ok := parser.IncompleteAt(err); ok && name != "" {
+				// Say where the command that never ends starts
+				fmt.Fprintf(sh.stderr, "%sline %d: %v\n", prefix, pos.Line, err)
+			} else {
+				fmt.Fprintf(sh.stderr, "%s%v\n", prefix, err)
+			}
+			sh.setResult(Result{Code: 2}, []int{2})
+			return 2, nil
+		}
+
+		status, err = sh.RunList(list)
+		if unwinding(err) {
+			return status, err
+		}
+	}
+}
+
+// located adds the script and line being run to an error message, as in
+// "traSH: setup.sh: line 3: foo: command not found"
+func (sh *Shell) located(msg string) string {
+	rest, ok := strings.CutPrefix(msg, "traSH: ")
+	if sh.source == "" || !ok {
+		return msg
+	}
+	return fmt.Sprintf("traSH: %s: line %d: %s", sh.source, sh.line, rest)
+}
+
+// report writes an error to the shell's stderr, saying where in the
+// script it happened
+func (sh *Shell) report(err error) {
+	fmt.Fprintln(sh.stderr, sh.located(err.Error()))
+}
+
+// sourcePath finds the file source runs. A name without a slash is looked
+// up in PATH first and then in the working directory.
+func (sh *Shell) sourcePath(name string) string {
+	if strings.Contains(name, "/") {
+		return name
+	}
+	path, _ := sh.vars.Get("PATH")
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			continue
+		}
+		full := filepath.Join(dir, name)
+		if info, err := os.Stat(sh.path(full)); err == nil && info.Mode().IsRegular() {
+			return full
+		}
+	}
+	return name
+}
+
+// SourceFile runs the commands in the file name right in the shell, with
+// args as the positional parameters while it runs if there are any. return
+// ends the file early. The error is ErrExit when the file runs exit, or
+// says why the file could not be read.
+func (sh *Shell) SourceFile(name string, args []string) (int, error) {
+	f, err := os.Open(sh.path(name))
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		return 1, fmt.Errorf("%s: %v", name, err)
+	}
+	defer f.Close()
+
+	if len(args) > 0 {
+		params := sh.params
+		sh.params = append([]string(nil), args...)
+		defer func() { sh.params = params }()
+	}
+	sh.sourceDepth++
+	defer func() { sh.sourceDepth-- }()
+
+	status, err := sh.runSource(bufio.NewReader(f), name)
+	var ret returnRequest
+	if errors.As(err, &ret) {
+		return int(ret), nil
+	}
+	return status, err
+}
+
+// HandleSource runs the commands in a file in the current shell, as if they
+// were typed there, with any further arguments as positional parameters
+func HandleSource(cmd *Command) error {
+	sh := cmd.sh
+	if sh == nil {
+		return errors.New("cannot be used here")
+	}
+	if len(cmd.args) == 0 {
+		fmt.Fprintln(cmd.errOut(), sh.located("traSH: "+cmd.command+": filename argument required"))
+		fmt.Fprintf(cmd.errOut(), "%s: usage: %s filename [arguments]\n", cmd.command, cmd.command)
+		return ExitStatus(2)
+	}
+
+	// Redirections on source apply to every command in the file
+	stdin, stdout, stderr := sh.stdin, sh.stdout, sh.stderr
+	sh.stdin, sh.stdout, sh.stderr = cmd.in(), cmd.out(), cmd.errOut()
+	defer func() { sh.stdin, sh.stdout, sh.stderr = stdin, stdout, stderr }()
+
+	status, err := sh.SourceFile(sh.sourcePath(cmd.args[0]), cmd.args[1:])
+	switch {
+	case errors.Is(err, ErrExit):
+		return exitRequest(status)
+	case err != nil:
+		return err
+	}
+	return ExitStatus(status)
+}
@@ -0,0 +1,238 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mush1e/traSH/internal/parser"
+)
+
+// setOptions are the options set turns on with -o name, or with the
+// letter if there is one, and off with +o name:
+//
+//	errexit    -e  exit as soon as a command fails, unless its status is tested
+//	noclobber  -C  > refuses to overwrite an existing file, >| still does
+//	noglob     -f  patterns are not expanded
+//	nounset    -u  expanding a variable that is not set is an error
+//	pipefail       a pipeline fails if any of its commands does
+//	xtrace     -x  print each command after expansion, after $PS4
+var setOptions = []struct {
+	name   string
+	letter rune
+}{
+	{"errexit", 'e'},
+	{"noclobber", 'C'},
+	{"noglob", 'f'},
+	{"nounset", 'u'},
+	{"pipefail", 0},
+	{"xtrace", 'x'},
+}
+
+const setUsage = "usage: set [-Cefux] [-o option] [--] [arg ...]"
+
+// isSetOption reports whether name is one of setOptions
+func isSetOption(name string) bool {
+	for _, o := range setOptions {
+		if o.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// optionFlags returns the letters of the options that are on, which is $-
+func (sh *Shell) optionFlags() string {
+	var flags strings.Builder
+	for _, o := range setOptions {
+		if o.letter != 0 && sh.options[o.name] {
+			flags.WriteRune(o.letter)
+		}
+	}
+	return flags.String()
+}
+
+// HandleSet lists every shell variable, turns options on (-e, -o pipefail)
+// and off (+e, +o pipefail), and replaces the positional parameters with
+// the arguments left, as in `set -- a b c`. `set -o` shows the options and
+// `set +o` prints the commands that restore them.
+func HandleSet(cmd *Command) error {
+	sh := cmd.sh
+	if len(cmd.args) == 0 {
+		for _, name := range sh.vars.Names() {
+			if sh.vars.IsArray(name) {
+				values, _ := sh.vars.GetArray(name)
+				fmt.Fprintf(cmd.out(), "%s=%s\n", name, arrayQuote(values))
+				continue
+			}
+			value, _ := sh.vars.Get(name)
+			fmt.Fprintf(cmd.out(), "%s=%s\n", name, shellQuote(value))
+		}
+		return nil
+	}
+
+	args := cmd.args
+	params := false
+	for len(args) > 0 {
+		arg := args[0]
+		if arg == "--" {
+			args, params = args[1:], true
+			break
+		}
+		if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			break
+		}
+		args = args[1:]
+
+		on := arg[0] == '-'
+		for _, letter := range arg[1:] {
+			if letter == 'o' {
+				if len(args) == 0 {
+					sh.printOptions(cmd, on)
+					continue
+				}
+				name := args[0]
+				args = args[1:]
+				if !isSetOption(name) {
+					return fmt.Errorf("%s: invalid option name\n%s", name, setUsage)
+				}
+				sh.options[name] = on
+				continue
+			}
+
+			found := false
+			for _, o := range setOptions {
+				if o.letter == letter {
+					sh.options[o.name], found = on, true
+				}
+			}
+			if !found {
+				return fmt.Errorf("%c%c: invalid option\n%s", arg[0], letter, setUsage)
+			}
+		}
+	}
+
+	if params || len(args) > 0 {
+		sh.SetParams(args)
+	}
+	return nil
+}
+
+// HandleShift drops the first n positional parameters, or the first one
+// without an argument. It fails and leaves them alone when there are fewer
+// than n.
+func HandleShift(cmd *Command) error {
+	if len(cmd.args) > 1 {
+		return fmt.Errorf("too many arguments")
+	}
+	n := 1
+	if len(cmd.args) == 1 {
+		var err error
+		if n, err = strconv.Atoi(cmd.args[0]); err != nil {
+			return fmt.Errorf("%s: numeric argument required", cmd.args[0])
+		}
+		if n < 0 {
+			return fmt.Errorf("%s: shift count out of range", cmd.args[0])
+		}
+	}
+	sh := cmd.sh
+	if n > len(sh.params) {
+		return ExitStatus(1)
+	}
+	sh.params = sh.params[n:]
+	return nil
+}
+
+// printOptions shows whether each option is on, or unless show is set
+// prints the set commands that put them back the way they are
+func (sh *Shell) printOptions(cmd *Command, show bool) {
+	for _, o := range setOptions {
+		on := sh.options[o.name]
+		switch {
+		case show && on:
+			fmt.Fprintf(cmd.out(), "%-15s\ton\n", o.name)
+		case show:
+			fmt.Fprintf(cmd.out(), "%-15s\toff\n", o.name)
+		case on:
+			fmt.Fprintf(cmd.out(), "set -o %s\n", o.name)
+		default:
+			fmt.Fprintf(cmd.out(), "set +o %s\n", o.name)
+		}
+	}
+}
+
+// trace writes cmd to stderr after $PS4, as set -x does
+func (sh *Shell) trace(cmd *Command) {
+	var words []string
+	for _, kv := range cmd.env {
+		name, value, _ := strings.Cut(kv, "=")
+		words = append(words, name+"="+shellQuote(value))
+	}
+	if cmd.command != "" {
+		words = append(words, shellQuote(cmd.command))
+	}
+	for _, arg := range cmd.args {
+		words = append(words, shellQuote(arg))
+	}
+	if len(words) == 0 {
+		return
+	}
+
+	ps4, ok := sh.vars.Get("PS4")
+	if !ok {
+		ps4 = "+ "
+	} else {
+		// Whatever $PS4 runs is not traced itself
+		sh.options["xtrace"] = false
+		if expanded, err := sh.expandString(ps4); err == nil {
+			ps4 = expanded
+		}
+		sh.options["xtrace"] = true
+	}
+	fmt.Fprintf(sh.stderr, "%s%s\n", ps4, strings.Join(words, " "))
+}
+
+// exitsOnError reports whether p failing ends the shell under set -e.
+// Commands whose status is tested, by if, while, &&, || or !, never do,
+// and neither does a compound command, whose failure comes from a command
+// inside it that was either tested or already ended the shell.
+func (sh *Shell) exitsOnError(p *parser.Pipeline) bool {
+	if !sh.options["errexit"] || p.Bang || sh.condition > 0 {
+		return false
+	}
+	if len(p.Commands) == 1 {
+		switch p.Commands[0].(type) {
+		case *parser.SimpleCommand, *parser.Subshell, *parser.ArithCommand, *parser.CondCommand:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// shellQuote returns s in a form the shell reads back as the same word
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range s {
+		if !isNameRune(r, false) && !strings.ContainsRune("-+./:@%,=", r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// arrayQuote formats the elements of an array the way set lists them
+func arrayQuote(values []string) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf("[%d]=%s", i, shellQuote(v))
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
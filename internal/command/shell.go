@@ -0,0 +1,203 @@
+package command
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mush1e/traSH/internal/parser"
+)
+
+// Shell holds the state commands run against: shell and environment
+// variables, functions, aliases, the positional parameters and the files commands
+// read from and write to unless redirected
+type Shell struct {
+	vars      *Vars
+	arg0      string   // $0
+	params    []string // positional parameters $1 ... $N
+	lastBgPid int      // $!
+	last      Result   // how the last pipeline finished, $? being its code
+	// substStatus is the status of the last command substitution, which
+	// becomes $? after a command consisting only of assignments
+	substStatus int
+	shopt       map[string]bool
+	options     map[string]bool // turned on and off by set, as in set -e
+	loopDepth   int             // how many loops the running command is nested in
+	funcs       map[string]*parser.FuncDecl
+	aliases     map[string]string
+	funcDepth   int // how many function calls are in progress
+	// source is the script being run, named in error messages along with
+	// the line of the running command, and sourceDepth counts the files
+	// being run by source, which return may leave
+	source      string
+	line        int
+	sourceDepth int
+	// condition is above 0 while running a command whose status is tested,
+	// such as the condition of an if or the left side of &&
+	condition int
+	// traps maps EXIT, ERR, DEBUG and signal names such as INT to the
+	// commands run for them, "" meaning the signal is ignored
+	traps  map[string]string
+	inTrap bool
+	level  int // how many subshells deep this shell is, 0 in the shell itself
+	// async is set in subshells running on a goroutine of their own, which
+	// must never hand the terminal to the commands they run
+	async bool
+	// group is the job a subshell runs for when that job has a leader,
+	// whose process group the jobs of the subshell join
+	group *Job
+	// dir is the working directory of a subshell, which must not move the
+	// whole process with cd. It is empty in the shell itself, which uses
+	// the process's.
+	dir string
+	// dirStack holds the directories saved by pushd, most recent first
+	dirStack []string
+	// commands remembers where commands were found, as hash shows
+	commands *commandCache
+
+	stdin, stdout, stderr *os.File
+}
+
+// NewShell returns a shell whose variables start out as the process
+// environment
+func NewShell() *Shell {
+	vars := NewVars(os.Environ())
+	vars.mirror = true
+	// os.Getwd keeps an inherited PWD as long as it is still accurate
+	if wd, err := os.Getwd(); err == nil {
+		vars.Set("PWD", wd)
+		vars.Export("PWD")
+	}
+	return &Shell{
+		vars:     vars,
+		arg0:     os.Args[0],
+		stdin:    os.Stdin,
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+		shopt:    make(map[string]bool),
+		options:  make(map[string]bool),
+		funcs:    make(map[string]*parser.FuncDecl),
+		aliases:  make(map[string]string),
+		traps:    make(map[string]string),
+		commands: newCommandCache(),
+	}
+}
+
+// subshell returns a copy of sh whose variables and positional parameters
+// can change without affecting sh
+func (sh *Shell) subshell() *Shell {
+	sub := *sh
+	sub.vars = sh.vars.clone()
+	sub.params = append([]string(nil), sh.params...)
+	sub.dirStack = append([]string(nil), sh.dirStack...)
+	sub.shopt = maps.Clone(sh.shopt)
+	sub.options = maps.Clone(sh.options)
+	sub.funcs = maps.Clone(sh.funcs)
+	sub.aliases = maps.Clone(sh.aliases)
+	sub.commands = sh.commands.clone()
+	// Traps are reset in a subshell, except that ignored signals stay
+	// ignored
+	sub.traps = make(map[string]string)
+	for name, handler := range sh.traps {
+		if handler == "" && name != "EXIT" && name != "ERR" && name != "DEBUG" {
+			sub.traps[name] = ""
+		}
+	}
+	sub.level++
+	if sub.dir == "" {
+		sub.dir, _ = os.Getwd()
+	}
+	return &sub
+}
+
+// path resolves a file name against the shell's working directory
+func (sh *Shell) path(name string) string {
+	if name == "" {
+		name = "."
+	}
+	if sh.dir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(sh.dir, name)
+}
+
+// SetParams replaces the positional parameters
+func (sh *Shell) SetParams(params []string) {
+	sh.params = append([]string(nil), params...)
+}
+
+// SetArg0 sets $0, which is the script's name when running one
+func (sh *Shell) SetArg0(name string) {
+	sh.arg0 = name
+}
+
+// Status returns how the last pipeline finished
+func (sh *Shell) Status() Result {
+	return sh.last
+}
+
+// setResult records how a pipeline finished as $? and the status of each
+// of its stages as PIPESTATUS
+func (sh *Shell) setResult(r Result, pipeStatus []int) {
+	sh.last = r
+	statuses := make([]string, len(pipeStatus))
+	for i, st := range pipeStatus {
+		statuses[i] = strconv.Itoa(st)
+	}
+	sh.vars.SetArray("PIPESTATUS", statuses)
+}
+
+// lookup returns the value of a variable or special parameter and whether
+// it is set
+func (sh *Shell) lookup(name string) (string, bool) {
+	switch name {
+	case "#":
+		return strconv.Itoa(len(sh.params)), true
+	case "@", "*":
+		return strings.Join(sh.params, sh.ifsJoiner()), true
+	case "?":
+		return strconv.Itoa(sh.last.Code), true
+	case "$":
+		return strconv.Itoa(os.Getpid()), true
+	case "!":
+		if sh.lastBgPid == 0 {
+			return "", false
+		}
+		return strconv.Itoa(sh.lastBgPid), true
+	case "0":
+		return sh.arg0, true
+	case "-":
+		return sh.optionFlags(), true
+	case "LINENO":
+		return strconv.Itoa(sh.line), true
+	}
+
+	if isDigits(name) {
+		n, _ := strconv.Atoi(name)
+		if n >= 1 && n <= len(sh.params) {
+			return sh.params[n-1], true
+		}
+		return "", false
+	}
+	return sh.vars.Get(name)
+}
+
+// ifs returns the field separators used for word splitting
+func (sh *Shell) ifs() string {
+	if ifs, ok := sh.vars.Get("IFS"); ok {
+		return ifs
+	}
+	return " \t\n"
+}
+
+// ifsJoiner returns what "$*" puts between positional parameters: the
+// first character of IFS
+func (sh *Shell) ifsJoiner() string {
+	ifs := sh.ifs()
+	if ifs == "" {
+		return ""
+	}
+	return string([]rune(ifs)[0])
+}
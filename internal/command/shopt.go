@@ -0,0 +1,94 @@
+package command
+
+import (
+	"fmt"
+	"slices"
+)
+
+// shoptNames are the options shopt knows about:
+//
+//	dotglob   patterns match hidden files too
+//	failglob  a pattern without matches is an error
+//	globstar  ** matches files and directories recursively
+//	nullglob  a pattern without matches expands to nothing
+var shoptNames = []string{"dotglob", "failglob", "globstar", "nullglob"}
+
+// HandleShopt shows or toggles shell options, as in `shopt -s globstar`.
+// With -o it works on the options of set instead, as in `shopt -so
+// pipefail`.
+func HandleShopt(cmd *Command) error {
+	sh := cmd.sh
+	set, unset, print := cmd.HasOpt('s'), cmd.HasOpt('u'), cmd.HasOpt('p')
+	if set && unset {
+		return fmt.Errorf("cannot set and unset shell options simultaneously")
+	}
+	known, options := shoptNames, sh.shopt
+	if cmd.HasOpt('o') {
+		known, options = nil, sh.options
+		for _, o := range setOptions {
+			known = append(known, o.name)
+		}
+	}
+
+	var names []string
+	for _, arg := range cmd.args {
+		if len(arg) > 1 && arg[0] == '-' {
+			for _, opt := range arg[1:] {
+				if opt != 's' && opt != 'u' && opt != 'p' && opt != 'q' && opt != 'o' {
+					return fmt.Errorf("-%c: invalid option\nusage: shopt [-pqsu] [-o] [optname ...]", opt)
+				}
+			}
+			continue
+		}
+		if !slices.Contains(known, arg) {
+			return fmt.Errorf("%s: invalid shell option name", arg)
+		}
+		names = append(names, arg)
+	}
+
+	if (set || unset) && len(names) > 0 {
+		for _, name := range names {
+			options[name] = set
+		}
+		return nil
+	}
+
+	if len(names) == 0 {
+		names = known
+	}
+	status := 0
+	for _, name := range names {
+		on := options[name]
+		if (set && !on) || (unset && on) {
+			continue
+		}
+		if !on {
+			status = 1
+		}
+		switch {
+		case cmd.HasOpt('q'):
+		case print && cmd.HasOpt('o'):
+			flag := "+o"
+			if on {
+				flag = "-o"
+			}
+			fmt.Fprintf(cmd.out(), "set %s %s\n", flag, name)
+		case print:
+			flag := "-u"
+			if on {
+				flag = "-s"
+			}
+			fmt.Fprintf(cmd.out(), "shopt %s %s\n", flag, name)
+		default:
+			state := "off"
+			if on {
+				state = "on"
+			}
+			fmt.Fprintf(cmd.out(), "%-15s\t%s\n", name, state)
+		}
+	}
+	if len(cmd.args) > 0 && status != 0 && !set && !unset {
+		return ExitStatus(status)
+	}
+	return nil
+}
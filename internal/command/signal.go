@@ -0,0 +1,242 @@
+package command
+
+import (
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// interruptPending is set when Ctrl-C reaches the shell itself, which
+// happens while it runs builtins and loops in-process rather than waiting
+// on a child that owns the terminal
+var interruptPending atomic.Bool
+
+// interactiveSignals are caught by an interactive shell even without a trap
+var interactiveSignals = []syscall.Signal{syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGHUP}
+
+// fatalSignals are caught by a non-interactive shell with an EXIT trap,
+// so that the trap still runs when one of them ends the shell
+var fatalSignals = []syscall.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
+
+// signalCatcher decides what becomes of each signal: whether the shell
+// catches it, and what it does with it once caught
+type signalCatcher struct {
+	mu          sync.Mutex
+	chans       map[syscall.Signal]chan os.Signal
+	interactive bool
+	hangup      func() // cleanup before an interactive shell exits on SIGHUP
+	trapped     map[syscall.Signal]bool
+	ignored     map[syscall.Signal]bool
+	ignoring    map[syscall.Signal]bool // what signal.Ignore was called for
+	exitTrap    bool
+	// pending lists the signals waiting for the shell to reach a safe
+	// point, where their traps run
+	pending    []syscall.Signal
+	hasPending atomic.Bool
+}
+
+// Signal dispositions belong to the process, so there is just the one
+var catcher = &signalCatcher{
+	chans:    make(map[syscall.Signal]chan os.Signal),
+	trapped:  make(map[syscall.Signal]bool),
+	ignored:  make(map[syscall.Signal]bool),
+	ignoring: make(map[syscall.Signal]bool),
+}
+
+// CatchSignals keeps an interactive shell alive through the signals meant
+// for the commands it runs. Ctrl-C and Ctrl-\ go to the foreground job,
+// SIGTERM is ignored, and a hangup is passed on to every job before the
+// shell calls cleanup and exits. Traps take precedence over all of this.
+func CatchSignals(cleanup func()) {
+	catcher.mu.Lock()
+	defer catcher.mu.Unlock()
+	catcher.interactive = true
+	catcher.hangup = cleanup
+	for _, sig := range interactiveSignals {
+		catcher.update(sig)
+	}
+}
+
+// isInteractive reports whether the shell reads commands from a terminal,
+// which only such a shell tells CatchSignals
+func isInteractive() bool {
+	catcher.mu.Lock()
+	defer catcher.mu.Unlock()
+	return catcher.interactive
+}
+
+// setTrap records that sig now has a handler, is ignored (handler "") or,
+// with reset set, is back to its default
+func (c *signalCatcher) setTrap(sig syscall.Signal, handler string, reset bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trapped[sig] = !reset && handler != ""
+	c.ignored[sig] = !reset && handler == ""
+	c.update(sig)
+}
+
+// setExitTrap records whether there is an EXIT trap, which a
+// non-interactive shell must still run when a signal ends it
+func (c *signalCatcher) setExitTrap(set bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.exitTrap = set
+	for _, sig := range fatalSignals {
+		c.update(sig)
+	}
+}
+
+// update makes the disposition of sig match the traps and the kind of
+// shell. Signals are caught rather than ignored unless a trap says so,
+// since children would inherit an ignored signal. c.mu must be held.
+func (c *signalCatcher) update(sig syscall.Signal) {
+	switch {
+	case c.ignored[sig]:
+		c.release(sig)
+		signal.Ignore(sig)
+		c.ignoring[sig] = true
+		return
+	case c.ignoring[sig]:
+		// Undo our own signal.Ignore, never one inherited from the parent
+		signal.Reset(sig)
+		delete(c.ignoring, sig)
+	}
+
+	if c.trapped[sig] ||
+		c.interactive && slices.Contains(interactiveSignals, sig) ||
+		c.exitTrap && slices.Contains(fatalSignals, sig) {
+		c.catch(sig)
+	} else {
+		c.release(sig)
+	}
+}
+
+// catch starts delivering sig to the shell
+func (c *signalCatcher) catch(sig syscall.Signal) {
+	if _, ok := c.chans[sig]; ok {
+		return
+	}
+	ch := make(chan os.Signal, 1)
+	c.chans[sig] = ch
+	signal.Notify(ch, sig)
+	go func() {
+		for range ch {
+			c.deliver(sig)
+		}
+	}()
+}
+
+// release stops catching sig
+func (c *signalCatcher) release(sig syscall.Signal) {
+	if ch, ok := c.chans[sig]; ok {
+		signal.Stop(ch)
+		close(ch)
+		delete(c.chans, sig)
+	}
+}
+
+// deliver handles a signal the shell caught. Anything with a trap, and
+// anything that ends a non-interactive shell, waits for a safe point;
+// an interactive shell deals with the rest right away.
+func (c *signalCatcher) deliver(sig syscall.Signal) {
+	c.mu.Lock()
+	if c.trapped[sig] || !c.interactive {
+		c.pending = append(c.pending, sig)
+		c.hasPending.Store(true)
+		c.mu.Unlock()
+		return
+	}
+	hangup := c.hangup
+	c.mu.Unlock()
+
+	switch sig {
+	case syscall.SIGINT, syscall.SIGQUIT:
+		interrupt(sig)
+	case syscall.SIGHUP:
+		hangupJobs()
+		if hangup != nil {
+			hangup()
+		}
+		os.Exit(128 + int(syscall.SIGHUP))
+	}
+}
+
+// firstPending returns the first signal waiting for a safe point, if any,
+// leaving it pending
+func (c *signalCatcher) firstPending() (syscall.Signal, bool) {
+	if !c.hasPending.Load() {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) == 0 {
+		return 0, false
+	}
+	return c.pending[0], true
+}
+
+// take removes and returns the pending signals that want reports wanted
+func (c *signalCatcher) take(want func(syscall.Signal) bool) []syscall.Signal {
+	if !c.hasPending.Load() {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var taken, left []syscall.Signal
+	for _, sig := range c.pending {
+		if want(sig) {
+			taken = append(taken, sig)
+		} else {
+			left = append(left, sig)
+		}
+	}
+	c.pending = left
+	c.hasPending.Store(len(left) > 0)
+	return taken
+}
+
+// interrupt deals with SIGINT or SIGQUIT sent to an interactive shell. A
+// foreground job in a process group of its own gets the signal passed on;
+// otherwise SIGINT stops whatever the shell is running at the end of the
+// current command, and SIGQUIT is ignored.
+func interrupt(sig syscall.Signal) {
+	jobs.mu.Lock()
+	j := jobs.fg
+	jobs.mu.Unlock()
+
+	if j != nil && j.pgid > 0 && j.pgid != jobControl.pgid {
+		j.signal(sig)
+		return
+	}
+	if sig == syscall.SIGINT {
+		interruptPending.Store(true)
+	}
+}
+
+// hangupJobs sends SIGHUP to every job, as a shell does when its terminal
+// goes away. Stopped jobs are continued so that they see it.
+func hangupJobs() {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+
+	all := jobs.jobs
+	if jobs.fg != nil {
+		all = append([]*Job{jobs.fg}, all...)
+	}
+	for _, j := range all {
+		j.signal(syscall.SIGHUP)
+		if j.state() == jobStopped {
+			j.signal(syscall.SIGCONT)
+		}
+	}
+}
+
+// takeInterrupt reports whether Ctrl-C reached the shell since it last
+// asked, and forgets about it
+func takeInterrupt() bool {
+	return interruptPending.Swap(false)
+}
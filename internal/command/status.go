@@ -0,0 +1,194 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Result describes how a command or pipeline finished
+type Result struct {
+	Code     int            // exit status, 128+N when killed by signal N
+	Signal   syscall.Signal // signal that killed or stopped it, if any
+	Duration time.Duration  // wall-clock time from start to finish
+}
+
+// Success reports whether the command exited with status 0
+func (r Result) Success() bool {
+	return r.Code == 0
+}
+
+// ErrExit is returned alongside the requested status when the exit builtin
+// asks the shell to terminate
+var ErrExit = errors.New("exit")
+
+// ExitStatus lets a builtin fail with a specific status without printing
+// anything
+type ExitStatus int
+
+func (e ExitStatus) Error() string {
+	return "exit status " + strconv.Itoa(int(e))
+}
+
+// exitRequest is what the exit builtin returns; it matches ErrExit
+type exitRequest int
+
+func (e exitRequest) Error() string {
+	return "exit"
+}
+
+func (e exitRequest) Is(target error) bool {
+	return target == ErrExit
+}
+
+// HandleExit asks the shell to terminate with the given status, or with
+// that of the last command
+func HandleExit(cmd *Command) error {
+	if len(cmd.args) == 0 {
+		if cmd.sh != nil {
+			return exitRequest(cmd.sh.last.Code)
+		}
+		return exitRequest(0)
+	}
+	code, err := strconv.Atoi(cmd.args[0])
+	if err != nil {
+		fmt.Fprintf(cmd.errOut(), "traSH: exit: %s: numeric argument required\n", cmd.args[0])
+		return exitRequest(2)
+	}
+	if len(cmd.args) > 1 {
+		// The shell stays, as it cannot tell which status was meant
+		return fmt.Errorf("too many arguments")
+	}
+	return exitRequest(code & 0xff)
+}
+
+// unwinding reports whether err asks to stop more than the command that
+// returned it, as exit, return, break and continue do
+func unwinding(err error) bool {
+	var lc *loopControl
+	var ret returnRequest
+	return errors.Is(err, ErrExit) || errors.As(err, &lc) || errors.As(err, &ret)
+}
+
+// asBuiltinError turns the outcome of a command a builtin ran on its
+// behalf into what the builtin returns, so that exit, return, break and
+// continue still take effect
+func asBuiltinError(status int, err error) error {
+	switch {
+	case errors.Is(err, ErrExit):
+		return exitRequest(status)
+	case err != nil:
+		return err
+	}
+	return ExitStatus(status)
+}
+
+// builtinStatus turns the error returned by a builtin into an exit status,
+// reporting it on the builtin's stderr on the way. Only a request to exit
+// the shell, to return from a function or to leave a loop is passed on as
+// an error.
+func builtinStatus(cmd *Command, err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+
+	var req exitRequest
+	if errors.As(err, &req) {
+		return int(req), ErrExit
+	}
+	var lc *loopControl
+	if errors.As(err, &lc) {
+		return 0, lc
+	}
+	var ret returnRequest
+	if errors.As(err, &ret) {
+		return int(ret), ret
+	}
+	var es ExitStatus
+	if errors.As(err, &es) {
+		return int(es), nil
+	}
+
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "traSH:") {
+		msg = fmt.Sprintf("traSH: %s: %s", cmd.command, msg)
+	}
+	if cmd.sh != nil {
+		msg = cmd.sh.located(msg)
+	}
+	fmt.Fprintln(cmd.errOut(), msg)
+	return 1, nil
+}
+
+// commandError carries a short user-facing message while keeping the
+// underlying cause around for statusOf
+type commandError struct {
+	msg string
+	err error
+}
+
+func (e *commandError) Error() string {
+	return e.msg
+}
+
+func (e *commandError) Unwrap() error {
+	return e.err
+}
+
+// startError describes why a command could not be started, the way users
+// expect from other shells
+func startError(name string, err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return &commandError{msg: fmt.Sprintf("traSH: %s: command not found", name), err: err}
+	}
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return &commandError{msg: fmt.Sprintf("traSH: %s: %v", name, pathErr.Err), err: err}
+	}
+	return &commandError{msg: fmt.Sprintf("traSH: %s: %v", name, err), err: err}
+}
+
+// redirectError is a redirection that failed before a command could be
+// started. It gives status 1, as it does for a builtin.
+type redirectError struct {
+	err error
+}
+
+func (e *redirectError) Error() string {
+	return e.err.Error()
+}
+
+func (e *redirectError) Unwrap() error {
+	return e.err
+}
+
+// statusOf maps the result of running a child process to a shell exit
+// status: 1 when a redirection failed, 127 when the command was not found,
+// 126 when it could not be executed, and 128+N when it was killed by
+// signal N
+func statusOf(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var redirErr *redirectError
+	if errors.As(err, &redirErr) {
+		return 1
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+		return 127
+	}
+	return 126
+}
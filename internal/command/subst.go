@@ -0,0 +1,58 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// commandSubst runs src the way $(src) does: in a subshell, with its
+// standard output captured and returned minus any trailing newlines
+func (sh *Shell) commandSubst(src string) (string, error) {
+	list, err := sh.Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("traSH: %v", err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return "", fmt.Errorf("traSH: pipe: %v", err)
+	}
+
+	// Read while the commands run, so they never block on a full pipe
+	var out []byte
+	done := make(chan struct{})
+	go func() {
+		out, _ = io.ReadAll(r)
+		close(done)
+	}()
+
+	sub := sh.subshell()
+	sub.stdout = w
+	// Like bash, set -e does not reach into command substitutions
+	sub.options["errexit"] = false
+	status, err := sub.RunList(list)
+	if err != nil && !unwinding(err) {
+		sh.report(err)
+	}
+	sh.substStatus = sub.RunExitTrap(status)
+
+	w.Close()
+	<-done
+	r.Close()
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// backquoted returns the command inside a pair of backquotes, where a
+// backslash only escapes $, ` and another backslash
+func backquoted(body []rune) string {
+	var sb strings.Builder
+	for i := 0; i < len(body); i++ {
+		if body[i] == '\\' && i+1 < len(body) && strings.ContainsRune("$`\\", body[i+1]) {
+			i++
+		}
+		sb.WriteRune(body[i])
+	}
+	return sb.String()
+}
@@ -0,0 +1,9 @@
+package command
+
+import "golang.org/x/sys/unix"
+
+// The requests that get and set terminal modes
+const (
+	ioctlGetTermios = unix.TCGETS
+	ioctlSetTermios = unix.TCSETS
+)
@@ -0,0 +1,375 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// testError is a malformed test expression, which gives status 2
+type testError string
+
+func (e testError) Error() string {
+	return string(e)
+}
+
+// unaryTests are the operators test takes with a single operand
+var unaryTests = map[string]bool{
+	"-a": true, "-b": true, "-c": true, "-d": true, "-e": true, "-f": true,
+	"-g": true, "-G": true, "-h": true, "-k": true, "-L": true, "-N": true,
+	"-O": true, "-p": true, "-r": true, "-s": true, "-S": true, "-t": true,
+	"-u": true, "-w": true, "-x": true, "-n": true, "-z": true, "-o": true,
+	"-v": true,
+}
+
+// binaryTests are the operators test puts between two operands
+var binaryTests = map[string]bool{
+	"=": true, "==": true, "!=": true, "<": true, ">": true,
+	"-eq": true, "-ne": true, "-lt": true, "-le": true, "-gt": true, "-ge": true,
+	"-nt": true, "-ot": true, "-ef": true,
+}
+
+// HandleTest evaluates its arguments as a conditional expression and
+// succeeds when it is true. As [ it also wants a closing ]. Malformed
+// expressions give status 2.
+func HandleTest(cmd *Command) error {
+	args := cmd.args
+	if cmd.command == "[" {
+		if len(args) == 0 || args[len(args)-1] != "]" {
+			return cmd.testFailed(testError("missing `]'"))
+		}
+		args = args[:len(args)-1]
+	}
+
+	t := &testParser{cmd: cmd, args: args}
+	ok, err := t.eval(len(args))
+	if err == nil && t.pos < len(args) {
+		err = testError("too many arguments")
+	}
+	if err != nil {
+		return cmd.testFailed(err)
+	}
+	if !ok {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// testFailed reports why the expression of test could not be evaluated
+func (c *Command) testFailed(err error) error {
+	msg := "traSH: " + c.command + ": " + err.Error()
+	if c.sh != nil {
+		msg = c.sh.located(msg)
+	}
+	fmt.Fprintln(c.errOut(), msg)
+	return ExitStatus(2)
+}
+
+// testParser evaluates the arguments of test. Up to four arguments follow
+// the POSIX rules, which look at how many there are before taking any of
+// them as an operator, so that test -f and test = = mean what they say.
+// Longer expressions are parsed with ! binding tighter than -a, and -a
+// tighter than -o.
+type testParser struct {
+	cmd  *Command
+	args []string
+	pos  int
+}
+
+// eval evaluates the next n arguments
+func (t *testParser) eval(n int) (bool, error) {
+	args := t.args[t.pos:]
+	switch n {
+	case 0:
+		return false, nil
+	case 1:
+		t.pos++
+		return args[0] != "", nil
+	case 2:
+		switch {
+		case args[0] == "!":
+			t.pos += 2
+			return args[1] == "", nil
+		case unaryTests[args[0]]:
+			t.pos += 2
+			return t.cmd.unaryTest(args[0], args[1])
+		}
+		return false, testError(args[0] + ": unary operator expected")
+	case 3:
+		switch {
+		case binaryTests[args[1]]:
+			t.pos += 3
+			return t.cmd.binaryTest(args[1], args[0], args[2])
+		case args[1] == "-a":
+			t.pos += 3
+			return args[0] != "" && args[2] != "", nil
+		case args[1] == "-o":
+			t.pos += 3
+			return args[0] != "" || args[2] != "", nil
+		case args[0] == "!":
+			t.pos++
+			ok, err := t.eval(2)
+			return !ok, err
+		case args[0] == "(" && args[2] == ")":
+			t.pos += 3
+			return args[1] != "", nil
+		}
+		return false, testError(args[1] + ": binary operator expected")
+	case 4:
+		switch {
+		case args[0] == "!":
+			t.pos++
+			ok, err := t.eval(3)
+			return !ok, err
+		case args[0] == "(" && args[3] == ")":
+			t.pos++
+			ok, err := t.eval(2)
+			t.pos++
+			return ok, err
+		}
+	}
+	return t.or()
+}
+
+// or parses expressions joined by -o
+func (t *testParser) or() (bool, error) {
+	ok, err := t.and()
+	for err == nil && t.peek() == "-o" {
+		t.pos++
+		var right bool
+		right, err = t.and()
+		ok = ok || right
+	}
+	return ok, err
+}
+
+// and parses expressions joined by -a
+func (t *testParser) and() (bool, error) {
+	ok, err := t.not()
+	for err == nil && t.peek() == "-a" {
+		t.pos++
+		var right bool
+		right, err = t.not()
+		ok = ok && right
+	}
+	return ok, err
+}
+
+// not parses an expression with any number of ! in front of it
+func (t *testParser) not() (bool, error) {
+	if t.peek() == "!" && t.pos+1 < len(t.args) {
+		t.pos++
+		ok, err := t.not()
+		return !ok, err
+	}
+	return t.primary()
+}
+
+// primary parses a parenthesized expression or a single test
+func (t *testParser) primary() (bool, error) {
+	if t.pos >= len(t.args) {
+		return false, testError("argument expected")
+	}
+	args := t.args[t.pos:]
+
+	if args[0] == "(" && len(args) > 1 {
+		t.pos++
+		ok, err := t.or()
+		if err != nil {
+			return false, err
+		}
+		if t.peek() != ")" {
+			return false, testError("`)' expected")
+		}
+		t.pos++
+		return ok, nil
+	}
+	if len(args) > 2 && binaryTests[args[1]] {
+		t.pos += 3
+		return t.cmd.binaryTest(args[1], args[0], args[2])
+	}
+	if unaryTests[args[0]] && len(args) > 1 {
+		t.pos += 2
+		return t.cmd.unaryTest(args[0], args[1])
+	}
+	t.pos++
+	return args[0] != "", nil
+}
+
+// peek returns the next argument, or "" at the end
+func (t *testParser) peek() string {
+	if t.pos < len(t.args) {
+		return t.args[t.pos]
+	}
+	return ""
+}
+
+// unaryTest applies the unary operator op to arg
+func (c *Command) unaryTest(op, arg string) (bool, error) {
+	switch op {
+	case "-n":
+		return arg != "", nil
+	case "-z":
+		return arg == "", nil
+	case "-o":
+		return c.sh != nil && c.sh.options[arg], nil
+	case "-v":
+		if c.sh == nil {
+			return false, nil
+		}
+		_, ok := c.sh.vars.Get(arg)
+		return ok, nil
+	case "-t":
+		fd, err := testInt(arg)
+		if err != nil {
+			return false, err
+		}
+		return c.isTerminal(fd), nil
+	case "-r":
+		return unix.Access(c.path(arg), unix.R_OK) == nil, nil
+	case "-w":
+		return unix.Access(c.path(arg), unix.W_OK) == nil, nil
+	case "-x":
+		return unix.Access(c.path(arg), unix.X_OK) == nil, nil
+	}
+
+	var st unix.Stat_t
+	var err error
+	if op == "-h" || op == "-L" {
+		err = unix.Lstat(c.path(arg), &st)
+	} else {
+		err = unix.Stat(c.path(arg), &st)
+	}
+	if err != nil {
+		return false, nil
+	}
+
+	kind := st.Mode & unix.S_IFMT
+	switch op {
+	case "-a", "-e":
+		return true, nil
+	case "-f":
+		return kind == unix.S_IFREG, nil
+	case "-d":
+		return kind == unix.S_IFDIR, nil
+	case "-h", "-L":
+		return kind == unix.S_IFLNK, nil
+	case "-p":
+		return kind == unix.S_IFIFO, nil
+	case "-S":
+		return kind == unix.S_IFSOCK, nil
+	case "-b":
+		return kind == unix.S_IFBLK, nil
+	case "-c":
+		return kind == unix.S_IFCHR, nil
+	case "-s":
+		return st.Size > 0, nil
+	case "-g":
+		return st.Mode&unix.S_ISGID != 0, nil
+	case "-u":
+		return st.Mode&unix.S_ISUID != 0, nil
+	case "-k":
+		return st.Mode&unix.S_ISVTX != 0, nil
+	case "-O":
+		return int(st.Uid) == os.Geteuid(), nil
+	case "-G":
+		return int(st.Gid) == os.Getegid(), nil
+	case "-N":
+		return st.Mtim.Nano() > st.Atim.Nano(), nil
+	}
+	return false, testError(op + ": unary operator expected")
+}
+
+// binaryTest applies the binary operator op to a and b
+func (c *Command) binaryTest(op, a, b string) (bool, error) {
+	switch op {
+	case "=", "==":
+		return a == b, nil
+	case "!=":
+		return a != b, nil
+	case "<":
+		return a < b, nil
+	case ">":
+		return a > b, nil
+	case "-nt", "-ot", "-ef":
+		return c.fileTest(op, a, b), nil
+	}
+
+	x, err := testInt(a)
+	if err != nil {
+		return false, err
+	}
+	y, err := testInt(b)
+	if err != nil {
+		return false, err
+	}
+	return compareInts(op, x, y), nil
+}
+
+// fileTest compares the files a and b. A file that exists is newer than
+// one that does not.
+func (c *Command) fileTest(op, a, b string) bool {
+	var sa, sb unix.Stat_t
+	errA := unix.Stat(c.path(a), &sa)
+	errB := unix.Stat(c.path(b), &sb)
+
+	switch op {
+	case "-nt":
+		return errA == nil && (errB != nil || sa.Mtim.Nano() > sb.Mtim.Nano())
+	case "-ot":
+		return errB == nil && (errA != nil || sa.Mtim.Nano() < sb.Mtim.Nano())
+	}
+	return errA == nil && errB == nil && sa.Dev == sb.Dev && sa.Ino == sb.Ino
+}
+
+// compareInts applies the integer comparison op to x and y
+func compareInts(op string, x, y int64) bool {
+	switch op {
+	case "-eq":
+		return x == y
+	case "-ne":
+		return x != y
+	case "-lt":
+		return x < y
+	case "-le":
+		return x <= y
+	case "-gt":
+		return x > y
+	}
+	return x >= y
+}
+
+// testInt reads an integer operand, which may have blanks around it
+func testInt(s string) (int64, error) {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, testError(s + ": integer expression expected")
+	}
+	return n, nil
+}
+
+// isTerminal reports whether fd of c is open on a terminal
+func (c *Command) isTerminal(fd int64) bool {
+	var f *os.File
+	switch fd {
+	case 0:
+		f = c.in()
+	case 1:
+		f = c.out()
+	case 2:
+		f = c.errOut()
+	default:
+		if fd < 0 || fd > 1<<20 {
+			return false
+		}
+		f = c.file(int(fd), nil)
+		if f == nil {
+			return term.IsTerminal(int(fd))
+		}
+	}
+	return f != nil && term.IsTerminal(int(f.Fd()))
+}
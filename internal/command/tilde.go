@@ -0,0 +1,66 @@
+package command
+
+import (
+	"os/user"
+	"strings"
+)
+
+// tilde expands the tilde prefix starting at rs[i]: everything up to the
+// next slash (or colon, in an assignment) provided none of it is quoted.
+// It returns the index just past the prefix, or i if there is nothing to
+// expand, in which case the ~ is kept as it is.
+func (x *expander) tilde(rs []rune, i int) int {
+	end := i + 1
+	for end < len(rs) && rs[end] != '/' && !(x.assignment && rs[end] == ':') {
+		if strings.ContainsRune("'\"\\$`", rs[end]) {
+			return i
+		}
+		end++
+	}
+
+	dir, ok := x.sh.tildeDir(string(rs[i+1 : end]))
+	if !ok {
+		return i
+	}
+	x.quoted(dir)
+	return end
+}
+
+// tildeDir returns what ~name stands for: the home directory for a bare ~
+// or ~user, the current directory for ~+ and the previous one for ~-, and
+// entry N of the directory stack for ~N, ~+N or ~-N
+func (sh *Shell) tildeDir(name string) (string, bool) {
+	if isDigits(name) {
+		name = "+" + name
+	}
+	if isStackArg(name) {
+		list := sh.dirList()
+		i, ok := stackIndex(name, len(list))
+		if !ok {
+			return "", false
+		}
+		return list[i], true
+	}
+
+	switch name {
+	case "":
+		if home, ok := sh.vars.Get("HOME"); ok {
+			return home, true
+		}
+		u, err := user.Current()
+		if err != nil {
+			return "", false
+		}
+		return u.HomeDir, true
+	case "+":
+		return sh.vars.Get("PWD")
+	case "-":
+		return sh.vars.Get("OLDPWD")
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", false
+	}
+	return u.HomeDir, true
+}
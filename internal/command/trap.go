@@ -0,0 +1,311 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/mush1e/traSH/internal/parser"
+	"golang.org/x/sys/unix"
+)
+
+// pseudoSignals are the conditions trap accepts besides real signals
+var pseudoSignals = []string{"EXIT", "DEBUG", "ERR"}
+
+// parseTrapSpec turns a signal spec such as INT, SIGINT, 2 or EXIT into the
+// name traps are kept under and, for real signals, the signal itself
+func parseTrapSpec(spec string) (string, syscall.Signal, bool) {
+	if isDigits(spec) {
+		n, err := strconv.Atoi(spec)
+		if err != nil {
+			return "", 0, false
+		}
+		if n == 0 {
+			return "EXIT", 0, true
+		}
+		name := unix.SignalName(syscall.Signal(n))
+		if name == "" {
+			return "", 0, false
+		}
+		return strings.TrimPrefix(name, "SIG"), syscall.Signal(n), true
+	}
+
+	name := strings.TrimPrefix(strings.ToUpper(spec), "SIG")
+	for _, p := range pseudoSignals {
+		if name == p {
+			return name, 0, true
+		}
+	}
+	sig := unix.SignalNum("SIG" + name)
+	if sig == 0 {
+		return "", 0, false
+	}
+	return name, sig, true
+}
+
+// trapOrder sorts trap names the way trap -p lists them: EXIT, then the
+// signals by number, then DEBUG and ERR
+func trapOrder(name string) int {
+	switch name {
+	case "EXIT":
+		return 0
+	case "DEBUG":
+		return 1000
+	case "ERR":
+		return 1001
+	}
+	return int(unix.SignalNum("SIG" + name))
+}
+
+func trapDisplayName(name string) string {
+	if unix.SignalNum("SIG"+name) != 0 {
+		return "SIG" + name
+	}
+	return name
+}
+
+// HandleTrap sets the commands run when the shell receives a signal, as
+// well as on EXIT, when a command fails (ERR) and before every command
+// (DEBUG). `trap - SIG` resets a signal, trap or trap -p lists what is
+// set, and an empty command ignores a signal:
+//
+//	trap '' SIG
+func HandleTrap(cmd *Command) error {
+	sh := cmd.sh
+	args := cmd.args
+	printTraps := false
+
+options:
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		switch args[0] {
+		case "--":
+			args = args[1:]
+			break options
+		case "-p":
+			printTraps = true
+		case "-l":
+			listSignals(cmd.out())
+			return nil
+		default:
+			return fmt.Errorf("%s: invalid option\nusage: trap [-lp] [[action] signal_spec ...]", args[0])
+		}
+		args = args[1:]
+	}
+
+	if len(args) == 0 || printTraps {
+		return sh.printTraps(cmd, args)
+	}
+
+	action, specs := args[0], args[1:]
+	reset := action == "-"
+	if len(specs) == 0 || isDigits(action) {
+		// `trap INT` and `trap 2 15` reset the signals named
+		specs, reset = args, true
+	}
+
+	failed := false
+	for _, spec := range specs {
+		name, sig, ok := parseTrapSpec(spec)
+		if !ok {
+			fmt.Fprintf(cmd.errOut(), "traSH: trap: %s: invalid signal specification\n", spec)
+			failed = true
+			continue
+		}
+
+		if reset {
+			delete(sh.traps, name)
+		} else {
+			sh.traps[name] = action
+		}
+		switch {
+		case name == "EXIT" && sh.level == 0:
+			catcher.setExitTrap(!reset && action != "")
+		case sig == syscall.SIGKILL || sig == syscall.SIGSTOP:
+			// Accepted, but nothing can catch these
+		case sig != 0:
+			catcher.setTrap(sig, action, reset)
+		}
+	}
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// printTraps lists the traps for the given signal specs, or every trap
+func (sh *Shell) printTraps(cmd *Command, specs []string) error {
+	var names []string
+	failed := false
+	if len(specs) == 0 {
+		for name := range sh.traps {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			return trapOrder(names[i]) < trapOrder(names[j])
+		})
+	}
+	for _, spec := range specs {
+		name, _, ok := parseTrapSpec(spec)
+		if !ok {
+			fmt.Fprintf(cmd.errOut(), "traSH: trap: %s: invalid signal specification\n", spec)
+			failed = true
+			continue
+		}
+		names = append(names, name)
+	}
+
+	for _, name := range names {
+		if handler, ok := sh.traps[name]; ok {
+			quoted := "'" + strings.ReplaceAll(handler, "'", `'\''`) + "'"
+			fmt.Fprintf(cmd.out(), "trap -- %s %s\n", quoted, trapDisplayName(name))
+		}
+	}
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// listSignals prints the signal names with their numbers, as trap -l does
+func listSignals(w io.Writer) {
+	var row []string
+	for n := 1; n < 32; n++ {
+		name := unix.SignalName(syscall.Signal(n))
+		if name == "" {
+			continue
+		}
+		row = append(row, fmt.Sprintf("%2d) %s", n, name))
+		if len(row) == 5 {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+}
+
+// runTrap runs the command string of a trap, leaving $? as it was. Exit,
+// and return inside a function, take effect as usual.
+func (sh *Shell) runTrap(handler string) (int, error) {
+	list, err := sh.Parse(handler)
+	if err != nil {
+		fmt.Fprintf(sh.stderr, "traSH: trap: %v\n", err)
+		return sh.last.Code, nil
+	}
+
+	last := sh.last
+	sh.inTrap = true
+	status, err := sh.RunList(list)
+	sh.inTrap = false
+	if err != nil {
+		return status, err
+	}
+	sh.last = last
+	return last.Code, nil
+}
+
+// runTraps runs the traps of the signals that arrived since the last safe
+// point, which is after each pipeline. A subshell leaves alone the
+// signals it has no trap for.
+func (sh *Shell) runTraps() (int, error) {
+	if sh.inTrap {
+		return sh.last.Code, nil
+	}
+	taken := catcher.take(func(sig syscall.Signal) bool {
+		_, ok := sh.traps[strings.TrimPrefix(unix.SignalName(sig), "SIG")]
+		return ok || sh.level == 0
+	})
+	for _, sig := range taken {
+		handler, ok := sh.traps[strings.TrimPrefix(unix.SignalName(sig), "SIG")]
+		if !ok {
+			// Caught only so that the EXIT trap runs before it ends us
+			sh.dieOf(sig)
+		}
+		if handler == "" {
+			continue
+		}
+		if status, err := sh.runTrap(handler); err != nil {
+			return status, err
+		}
+	}
+	return sh.last.Code, nil
+}
+
+// dieOf runs the EXIT trap and then lets sig end the shell the way it
+// would have without one
+func (sh *Shell) dieOf(sig syscall.Signal) {
+	sh.RunExitTrap(128 + int(sig))
+	signal.Reset(sig)
+	syscall.Kill(os.Getpid(), sig)
+	os.Exit(128 + int(sig))
+}
+
+// RunExitTrap runs the EXIT trap, if any, as the shell is about to exit
+// with status. It returns the status to exit with, which exit inside the
+// trap may change.
+func (sh *Shell) RunExitTrap(status int) int {
+	handler := sh.traps["EXIT"]
+	delete(sh.traps, "EXIT")
+	if handler == "" {
+		return status
+	}
+
+	list, err := sh.Parse(handler)
+	if err != nil {
+		fmt.Fprintf(sh.stderr, "traSH: trap: %v\n", err)
+		return status
+	}
+	sh.last = Result{Code: status}
+	sh.inTrap = true
+	if code, err := sh.RunList(list); errors.Is(err, ErrExit) {
+		return code
+	}
+	return status
+}
+
+// tracedCommand reports whether the DEBUG and ERR traps apply to p. They
+// are not inherited by functions, and a compound command only counts
+// through the commands inside it.
+func (sh *Shell) tracedCommand(p *parser.Pipeline) bool {
+	if sh.inTrap || sh.funcDepth > 0 {
+		return false
+	}
+	if len(p.Commands) == 1 {
+		switch p.Commands[0].(type) {
+		case *parser.SimpleCommand, *parser.Subshell, *parser.ArithCommand, *parser.CondCommand:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// runDebugTrap runs the DEBUG trap before p. BASH_COMMAND is set to p for
+// this trap and the ERR trap to look at.
+func (sh *Shell) runDebugTrap(p *parser.Pipeline) (int, error) {
+	if sh.traps["DEBUG"] == "" && sh.traps["ERR"] == "" || !sh.tracedCommand(p) {
+		return sh.last.Code, nil
+	}
+	sh.vars.Set("BASH_COMMAND", p.String())
+	if handler := sh.traps["DEBUG"]; handler != "" {
+		return sh.runTrap(handler)
+	}
+	return sh.last.Code, nil
+}
+
+// runErrTrap runs the ERR trap after p failed, unless its status was
+// being tested by if, while, && and the like
+func (sh *Shell) runErrTrap(p *parser.Pipeline) (int, error) {
+	handler := sh.traps["ERR"]
+	if handler == "" || p.Bang || sh.condition > 0 || !sh.tracedCommand(p) {
+		return sh.last.Code, nil
+	}
+	return sh.runTrap(handler)
+}
@@ -0,0 +1,262 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mush1e/traSH/internal/parser"
+)
+
+// meaning is one of the things a command name can stand for
+type meaning struct {
+	kind   string // alias, keyword, function, builtin or file
+	text   string // the alias value, function definition or file path
+	hashed bool   // a file the shell had found before
+}
+
+// meanings lists what name stands for, in the order the shell tries them,
+// so that the first is what would run. Only that one is looked for unless
+// all is set. Functions are left out when funcs is not set, and path is
+// searched for files.
+func (sh *Shell) meanings(name string, all, funcs bool, path string) []meaning {
+	var found []meaning
+	add := func(m meaning) bool {
+		found = append(found, m)
+		return !all
+	}
+
+	if value, ok := sh.aliases[name]; ok && add(meaning{kind: "alias", text: value}) {
+		return found
+	}
+	if parser.IsReservedWord(name) && add(meaning{kind: "keyword"}) {
+		return found
+	}
+	if f, ok := sh.funcs[name]; ok && funcs && add(meaning{kind: "function", text: f.String()}) {
+		return found
+	}
+	if isBuiltin(name) && add(meaning{kind: "builtin"}) {
+		return found
+	}
+
+	if strings.Contains(name, "/") {
+		if isExecutable(sh.path(name)) {
+			found = append(found, meaning{kind: "file", text: name})
+		}
+		return found
+	}
+	if !all {
+		if p, ok := sh.hashedPath(name); ok {
+			return append(found, meaning{kind: "file", text: p, hashed: true})
+		}
+	}
+	for _, p := range sh.searchPath(name, path, all) {
+		found = append(found, meaning{kind: "file", text: p})
+	}
+	return found
+}
+
+// describe says what m is the way type does
+func describe(name string, m meaning) string {
+	switch m.kind {
+	case "alias":
+		return fmt.Sprintf("%s is aliased to `%s'", name, m.text)
+	case "keyword":
+		return name + " is a shell keyword"
+	case "function":
+		return name + " is a function\n" + m.text
+	case "builtin":
+		return name + " is a shell builtin"
+	case "file":
+		if m.hashed {
+			return fmt.Sprintf("%s is hashed (%s)", name, m.text)
+		}
+		return name + " is " + m.text
+	}
+	return name
+}
+
+// HandleType tells what each name would run: an alias, keyword, function,
+// builtin or file. -a shows everything the name could stand for, -f leaves
+// out functions, -t prints just the kind, -p just the file and -P looks
+// for a file in PATH whatever else the name is.
+func HandleType(cmd *Command) error {
+	sh := cmd.sh
+	var all, noFuncs, kindOnly, fileOnly, forcePath bool
+	args := cmd.args
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for _, opt := range arg[1:] {
+			switch opt {
+			case 'a':
+				all = true
+			case 'f':
+				noFuncs = true
+			case 't':
+				kindOnly = true
+			case 'p':
+				fileOnly = true
+			case 'P':
+				forcePath = true
+			default:
+				return fmt.Errorf("-%c: invalid option\nusage: type [-afptP] name [name ...]", opt)
+			}
+		}
+	}
+
+	path, _ := sh.vars.Get("PATH")
+	failed := false
+	for _, name := range args {
+		var found []meaning
+		if forcePath {
+			for _, p := range sh.searchPath(name, path, all) {
+				found = append(found, meaning{kind: "file", text: p})
+			}
+		} else {
+			found = sh.meanings(name, all, !noFuncs, path)
+		}
+		if len(found) == 0 {
+			if !kindOnly && !fileOnly && !forcePath {
+				fmt.Fprintf(cmd.errOut(), "traSH: type: %s: not found\n", name)
+			}
+			failed = true
+			continue
+		}
+
+		for _, m := range found {
+			switch {
+			case kindOnly:
+				fmt.Fprintln(cmd.out(), m.kind)
+			case fileOnly || forcePath:
+				if m.kind == "file" {
+					fmt.Fprintln(cmd.out(), m.text)
+				}
+			default:
+				fmt.Fprintln(cmd.out(), describe(name, m))
+			}
+		}
+	}
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// HandleWhich prints where in PATH each command is found, or with -a every
+// place it is found. Names that are not found make the status 1.
+func HandleWhich(cmd *Command) error {
+	sh := cmd.sh
+	all := false
+	args := cmd.args
+	if len(args) > 0 && args[0] == "-a" {
+		all, args = true, args[1:]
+	}
+
+	path, _ := sh.vars.Get("PATH")
+	failed := false
+	for _, name := range args {
+		var found []string
+		if strings.Contains(name, "/") {
+			if isExecutable(sh.path(name)) {
+				found = []string{name}
+			}
+		} else {
+			found = sh.searchPath(name, path, all)
+		}
+		if len(found) == 0 {
+			failed = true
+		}
+		for _, p := range found {
+			fmt.Fprintln(cmd.out(), p)
+		}
+	}
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// HandleCommandBuiltin runs a command by name, skipping any function of the
+// same name, so that a function can wrap the command it is named after.
+// -p searches a default PATH that finds the standard utilities. -v prints
+// what a name would run instead, in a form the shell can read back, and
+// -V describes it as type does.
+func HandleCommandBuiltin(cmd *Command) error {
+	sh := cmd.sh
+	var defaultPATH, short, verbose bool
+	args := cmd.args
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for _, opt := range arg[1:] {
+			switch opt {
+			case 'p':
+				defaultPATH = true
+			case 'v':
+				short = true
+			case 'V':
+				verbose = true
+			default:
+				return fmt.Errorf("-%c: invalid option\nusage: command [-pVv] command [arg ...]", opt)
+			}
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	path, _ := sh.vars.Get("PATH")
+	if defaultPATH {
+		path = defaultPath
+	}
+
+	if short || verbose {
+		failed := false
+		for _, name := range args {
+			found := sh.meanings(name, false, true, path)
+			switch {
+			case len(found) == 0:
+				if verbose {
+					fmt.Fprintf(cmd.errOut(), "traSH: command: %s: not found\n", name)
+				}
+				failed = true
+			case verbose:
+				fmt.Fprintln(cmd.out(), describe(name, found[0]))
+			case found[0].kind == "alias":
+				fmt.Fprintf(cmd.out(), "alias %s='%s'\n", name, strings.ReplaceAll(found[0].text, "'", `'\''`))
+			case found[0].kind == "file":
+				fmt.Fprintln(cmd.out(), found[0].text)
+			default:
+				fmt.Fprintln(cmd.out(), name)
+			}
+		}
+		if failed {
+			return ExitStatus(1)
+		}
+		return nil
+	}
+
+	inner := &Command{files: cmd.files, env: cmd.env, sh: sh}
+	inner.setWords(args)
+	if defaultPATH && !isBuiltin(inner.command) && !strings.Contains(inner.command, "/") {
+		found := sh.searchPath(inner.command, path, false)
+		if len(found) == 0 {
+			fmt.Fprintln(cmd.errOut(), sh.located(fmt.Sprintf("traSH: %s: command not found", inner.command)))
+			return ExitStatus(127)
+		}
+		inner.exe = found[0]
+	}
+
+	status, err := runCommand(inner)
+	if err != nil && !unwinding(err) {
+		fmt.Fprintln(cmd.errOut(), sh.located(err.Error()))
+		err = nil
+	}
+	return asBuiltinError(status, err)
+}
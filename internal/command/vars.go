@@ -0,0 +1,405 @@
+package command
+
+import (
+	"maps"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mush1e/traSH/internal/parser"
+)
+
+// variable is a single shell variable
+type variable struct {
+	value string
+	// array holds the elements of an indexed array, whose plain value is
+	// its first element. It is nil for ordinary variables.
+	array    []string
+	exported bool
+}
+
+// scalar returns the plain value of vr
+func (vr *variable) scalar() string {
+	if vr.array != nil {
+		if len(vr.array) == 0 {
+			return ""
+		}
+		return vr.array[0]
+	}
+	return vr.value
+}
+
+// Vars is the shell's variable store. It tells plain shell variables apart
+// from exported ones, which make up the environment of child processes.
+type Vars struct {
+	mu   sync.RWMutex
+	vars map[string]*variable
+	// mirror keeps the process environment in sync with the exported
+	// variables, so that library code relying on os.Getenv sees them too
+	mirror bool
+	// scopes holds for each function call in progress the variables its
+	// local declarations shadowed, nil for those that were unset
+	scopes []map[string]*variable
+	// pending holds names exported before they were set, which are only
+	// passed on once they get a value
+	pending map[string]bool
+}
+
+// NewVars returns a store holding the given environment, all exported
+func NewVars(environ []string) *Vars {
+	v := &Vars{vars: make(map[string]*variable)}
+	for _, kv := range environ {
+		if name, value, ok := strings.Cut(kv, "="); ok && parser.IsName(name) {
+			v.vars[name] = &variable{value: value, exported: true}
+		}
+	}
+	return v
+}
+
+// clone returns an independent copy of v that leaves the process
+// environment alone
+func (v *Vars) clone() *Vars {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	c := &Vars{vars: make(map[string]*variable, len(v.vars)), pending: maps.Clone(v.pending)}
+	for name, vr := range v.vars {
+		c.vars[name] = copyVariable(vr)
+	}
+	for _, scope := range v.scopes {
+		saved := make(map[string]*variable, len(scope))
+		for name, vr := range scope {
+			saved[name] = copyVariable(vr)
+		}
+		c.scopes = append(c.scopes, saved)
+	}
+	return c
+}
+
+func copyVariable(vr *variable) *variable {
+	if vr == nil {
+		return nil
+	}
+	copied := *vr
+	if vr.array != nil {
+		copied.array = append([]string{}, vr.array...)
+	}
+	return &copied
+}
+
+// Get returns the value of name and whether it is set at all
+func (v *Vars) Get(name string) (string, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if vr, ok := v.vars[name]; ok {
+		return vr.scalar(), true
+	}
+	return "", false
+}
+
+// GetArray returns the elements of name. An ordinary variable counts as an
+// array of one element.
+func (v *Vars) GetArray(name string) ([]string, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	vr, ok := v.vars[name]
+	switch {
+	case !ok:
+		return nil, false
+	case vr.array != nil:
+		return append([]string{}, vr.array...), true
+	default:
+		return []string{vr.value}, true
+	}
+}
+
+// SetArray turns name into an array holding values. Arrays are never
+// passed on to child processes.
+func (v *Vars) SetArray(name string, values []string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	vr, ok := v.vars[name]
+	if !ok {
+		vr = &variable{exported: v.pending[name]}
+		v.vars[name] = vr
+		delete(v.pending, name)
+	}
+	vr.value = ""
+	vr.array = append([]string{}, values...)
+	if vr.exported && v.mirror {
+		os.Unsetenv(name)
+	}
+}
+
+// IsArray reports whether name is an indexed array
+func (v *Vars) IsArray(name string) bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	vr, ok := v.vars[name]
+	return ok && vr.array != nil
+}
+
+// Set assigns value to name, keeping its exported flag
+func (v *Vars) Set(name, value string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	vr, ok := v.vars[name]
+	if !ok {
+		vr = &variable{exported: v.pending[name]}
+		v.vars[name] = vr
+		delete(v.pending, name)
+	}
+	if vr.array != nil {
+		// Assigning to an array sets its first element
+		if len(vr.array) == 0 {
+			vr.array = append(vr.array, value)
+		} else {
+			vr.array[0] = value
+		}
+		return
+	}
+	vr.value = value
+	if vr.exported && v.mirror {
+		os.Setenv(name, value)
+	}
+}
+
+// Export marks name as exported. An unset name stays unset, and is passed
+// on once it is assigned.
+func (v *Vars) Export(name string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	vr, ok := v.vars[name]
+	if !ok {
+		if v.pending == nil {
+			v.pending = make(map[string]bool)
+		}
+		v.pending[name] = true
+		return
+	}
+	vr.exported = true
+	if v.mirror && vr.array == nil {
+		os.Setenv(name, vr.value)
+	}
+}
+
+// Unexport turns name back into a plain shell variable
+func (v *Vars) Unexport(name string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	delete(v.pending, name)
+	if vr, ok := v.vars[name]; ok {
+		vr.exported = false
+		if v.mirror {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// IsExported reports whether name is set and exported
+func (v *Vars) IsExported(name string) bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	vr, ok := v.vars[name]
+	return ok && vr.exported
+}
+
+// Unset removes name altogether
+func (v *Vars) Unset(name string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	delete(v.vars, name)
+	delete(v.pending, name)
+	if v.mirror {
+		os.Unsetenv(name)
+	}
+}
+
+// PushScope starts a scope for local variables, as a function call does
+func (v *Vars) PushScope() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.scopes = append(v.scopes, make(map[string]*variable))
+}
+
+// PopScope ends the innermost scope, bringing back the variables its local
+// declarations shadowed
+func (v *Vars) PopScope() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if len(v.scopes) == 0 {
+		return
+	}
+	scope := v.scopes[len(v.scopes)-1]
+	v.scopes = v.scopes[:len(v.scopes)-1]
+
+	for name, old := range scope {
+		if old == nil {
+			delete(v.vars, name)
+		} else {
+			v.vars[name] = old
+		}
+		if !v.mirror {
+			continue
+		}
+		if old != nil && old.exported && old.array == nil {
+			os.Setenv(name, old.value)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// Local makes name local to the innermost scope, leaving it unset until it
+// is assigned. A local copy of an exported variable is exported too, but
+// starts out empty. It reports false when no scope is active.
+func (v *Vars) Local(name string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if len(v.scopes) == 0 {
+		return false
+	}
+	scope := v.scopes[len(v.scopes)-1]
+	if _, ok := scope[name]; ok {
+		return true
+	}
+	old := v.vars[name]
+	scope[name] = old
+	delete(v.vars, name)
+	if old != nil && old.exported {
+		v.vars[name] = &variable{exported: true}
+		if v.mirror {
+			os.Setenv(name, "")
+		}
+	}
+	return true
+}
+
+// LocalNames returns the names of the variables local to the innermost
+// scope that are set, in sorted order
+func (v *Vars) LocalNames() []string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if len(v.scopes) == 0 {
+		return nil
+	}
+	var names []string
+	for name := range v.scopes[len(v.scopes)-1] {
+		if _, ok := v.vars[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Names returns the names of all variables in sorted order
+func (v *Vars) Names() []string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	names := make([]string, 0, len(v.vars))
+	for name := range v.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExportedNames returns the names of all exported variables in sorted
+// order, including those exported but not yet set
+func (v *Vars) ExportedNames() []string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	var names []string
+	for name, vr := range v.vars {
+		if vr.exported {
+			names = append(names, name)
+		}
+	}
+	for name := range v.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Environ returns the exported variables as NAME=value pairs, sorted by name
+func (v *Vars) Environ() []string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	var env []string
+	for name, vr := range v.vars {
+		if vr.exported && vr.array == nil {
+			env = append(env, name+"="+vr.value)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// environWith returns the environment with extra NAME=value pairs layered on
+// top, as used for `NAME=value cmd`
+func (v *Vars) environWith(extra []string) []string {
+	env := v.Environ()
+	if len(extra) == 0 {
+		return env
+	}
+
+	overridden := make(map[string]bool)
+	for _, kv := range extra {
+		name, _, _ := strings.Cut(kv, "=")
+		overridden[name] = true
+	}
+	merged := make([]string, 0, len(env)+len(extra))
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if !overridden[name] {
+			merged = append(merged, kv)
+		}
+	}
+	return append(merged, extra...)
+}
+
+// setTemporarily assigns NAME=value pairs and returns a function that puts
+// the previous values back
+func (v *Vars) setTemporarily(assigns []string) func() {
+	type saved struct {
+		name  string
+		value string
+		set   bool
+	}
+	var restore []saved
+	for _, kv := range assigns {
+		name, value, _ := strings.Cut(kv, "=")
+		old, set := v.Get(name)
+		restore = append(restore, saved{name, old, set})
+		v.Set(name, value)
+	}
+
+	return func() {
+		for i := len(restore) - 1; i >= 0; i-- {
+			if r := restore[i]; r.set {
+				v.Set(r.name, r.value)
+			} else {
+				v.Unset(r.name)
+			}
+		}
+	}
+}
@@ -68,8 +68,63 @@ func (ib *InputBuffer) isFirstWord() bool {
 	return true
 }
 
+// commandName returns the first word typed, which names the command
+func (ib *InputBuffer) commandName() string {
+	fields := strings.Fields(string(ib.content))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// DirStack, when set, returns the directories saved by pushd, which
+// completion offers for cd and pushd
+var DirStack func() []string
+
+// withDirStack puts the directories on the stack that match prefix in
+// front of the path suggestions
+func withDirStack(prefix string, suggestions []string) []string {
+	if DirStack == nil {
+		return suggestions
+	}
+	home, _ := os.UserHomeDir()
+	seen := make(map[string]bool)
+	for _, s := range suggestions {
+		seen[s] = true
+	}
+
+	var stack []string
+	for _, dir := range DirStack() {
+		suggestion := dir
+		if home != "" && strings.HasPrefix(dir, home+"/") && strings.HasPrefix(prefix, "~") {
+			suggestion = "~" + strings.TrimPrefix(dir, home)
+		}
+		if !strings.HasSuffix(suggestion, "/") {
+			suggestion += "/"
+		}
+		if strings.HasPrefix(suggestion, cleanPath(prefix)) && !seen[suggestion] {
+			stack = append(stack, suggestion)
+			seen[suggestion] = true
+		}
+	}
+	return append(stack, suggestions...)
+}
+
+// Executables, when set, lists the commands in PATH from the shell's
+// cache, which saves reading every PATH directory on each Tab
+var Executables func() []string
+
 func getCommandSuggestions(prefix string) []string {
 	suggestions := make([]string, 0)
+	if Executables != nil {
+		for _, name := range Executables() {
+			if strings.HasPrefix(name, prefix) {
+				suggestions = append(suggestions, name)
+			}
+		}
+		return suggestions
+	}
+
 	seen := make(map[string]bool)
 	pathEnv := os.Getenv("PATH")
 	dirs := strings.Split(pathEnv, ":")
@@ -2,9 +2,11 @@ package io
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"unicode"
 
 	"github.com/mush1e/traSH/utils"
@@ -30,6 +32,49 @@ const (
 
 var history = NewHistory()
 
+// stdin is what every line is read from. It takes input from the terminal
+// a byte at a time, so that it never holds on to input typed for the
+// commands the shell runs next, such as read.
+var stdin = bufio.NewReader(byteReader{os.Stdin})
+
+// byteReader reads no more than a byte per call
+type byteReader struct {
+	f *os.File
+}
+
+func (b byteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return b.f.Read(p[:1])
+}
+
+// cooked holds the terminal modes to go back to while ReadLine has the
+// terminal in raw mode
+var cooked struct {
+	mu    sync.Mutex
+	state *term.State
+}
+
+// RestoreTerminal takes the terminal out of raw mode if ReadLine left it
+// there, as when the shell has to exit in the middle of reading a line
+func RestoreTerminal() {
+	cooked.mu.Lock()
+	defer cooked.mu.Unlock()
+	if cooked.state != nil {
+		term.Restore(int(os.Stdin.Fd()), cooked.state)
+		cooked.state = nil
+	}
+}
+
+var (
+	// ErrInterrupted is returned by ReadLine when the user pressed Ctrl-C
+	ErrInterrupted = errors.New("interrupted")
+	// ErrEOF is returned by ReadLine on Ctrl-D at an empty line, or when
+	// the input is closed
+	ErrEOF = errors.New("end of input")
+)
+
 type InputBuffer struct {
 	content      []rune
 	cursor       int
@@ -147,36 +192,52 @@ func (ib *InputBuffer) render() {
 	fmt.Printf("\r\033[%dC", ib.promptLen+1+ib.cursor)
 }
 
+// ReadUserInput reads a line with ReadLine, turning Ctrl-C into an empty
+// line and Ctrl-D into "exit"
 func ReadUserInput(prompt string) string {
+	line, err := ReadLine(prompt)
+	switch err {
+	case ErrInterrupted:
+		return ""
+	case ErrEOF:
+		return "exit"
+	}
+	return line
+}
+
+// ReadLine reads a line of input with editing, history and completion
+func ReadLine(prompt string) (string, error) {
 	fd := int(os.Stdin.Fd())
 	oldState, err := term.MakeRaw(fd)
 	if err != nil {
-		fmt.Println("Failed to enter raw mode, fallback")
 		return readBasicInput(prompt)
 	}
-	defer term.Restore(fd, oldState)
+	cooked.mu.Lock()
+	cooked.state = oldState
+	cooked.mu.Unlock()
+	defer RestoreTerminal()
 
 	buffer := NewInputBuffer(prompt)
-	reader := bufio.NewReader(os.Stdin)
+	reader := stdin
 	buffer.render()
 
 	for {
 		char, _, err := reader.ReadRune()
 		if err != nil {
-			break
+			return buffer.getText(), ErrEOF
 		}
 		switch char {
 		case KeyEnter:
 			fmt.Print("\r\n")
 			history.Add(buffer.getText())
-			return buffer.getText()
+			return buffer.getText(), nil
 		case KeyCtrlC:
 			fmt.Print("^C\n")
-			return ""
+			return "", ErrInterrupted
 		case KeyCtrlD:
 			if len(buffer.content) == 0 {
 				fmt.Print("\n")
-				return "exit"
+				return "", ErrEOF
 			}
 			buffer.deleteForward()
 		case KeyBackspace:
@@ -195,6 +256,9 @@ func ReadUserInput(prompt string) string {
 					buffer.suggestions = getCommandSuggestions(buffer.lastPrefix)
 				} else {
 					buffer.suggestions = getFilePathSuggestions(buffer.lastPrefix)
+					if cmd := buffer.commandName(); cmd == "cd" || cmd == "pushd" {
+						buffer.suggestions = withDirStack(buffer.lastPrefix, buffer.suggestions)
+					}
 				}
 			}
 
@@ -227,7 +291,6 @@ func ReadUserInput(prompt string) string {
 		}
 		buffer.render()
 	}
-	return buffer.getText()
 }
 
 func handleEscapeSequence(reader *bufio.Reader, buffer *InputBuffer) bool {
@@ -272,9 +335,17 @@ func handleEscapeSequence(reader *bufio.Reader, buffer *InputBuffer) bool {
 	return false
 }
 
-func readBasicInput(prompt string) string {
+// readBasicInput reads a plain line for when the terminal cannot be put in
+// raw mode. A last line without a newline still counts; after it comes
+// ErrEOF.
+func readBasicInput(prompt string) (string, error) {
 	fmt.Print(prompt + " ")
-	reader := bufio.NewReader(os.Stdin)
-	input, _ := reader.ReadString('\n')
-	return strings.TrimSpace(input)
+	input, err := stdin.ReadString('\n')
+	if err != nil && input == "" {
+		fmt.Println()
+		return "", ErrEOF
+	}
+	input = strings.TrimRight(input, "\r\n")
+	history.Add(input)
+	return input, nil
 }
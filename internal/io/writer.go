@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/mush1e/traSH/config"
@@ -37,7 +38,9 @@ func WritePrompt(w io.Writer) {
 	fmt.Fprintf(w, "%v | %v ", currPath, coloredPrompt)
 }
 
-func BuildPrompt() string {
+// BuildPrompt returns the prompt for the next line. A non-zero status of
+// the last command is shown in red before the prompt symbol.
+func BuildPrompt(lastStatus int) string {
 	conf := config.GetConfig()
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -52,5 +55,10 @@ func BuildPrompt() string {
 		}
 	}
 
+	if lastStatus != 0 {
+		return utils.Colorize(conf.Prompt+" "+cwd, conf.PromptColor) +
+			utils.Colorize(" ["+strconv.Itoa(lastStatus)+"]", "red") +
+			utils.Colorize(conf.PromptSymbol, conf.PromptColor)
+	}
 	return utils.Colorize(conf.Prompt+" "+cwd+conf.PromptSymbol, conf.PromptColor)
 }
@@ -0,0 +1,39 @@
+package parser
+
+import (
+	"slices"
+	"strings"
+)
+
+// expandAlias replaces the word at the current token with the tokens of its
+// alias, again and again for as long as the result starts with an alias of
+// its own. An alias is never expanded inside its own expansion, so that
+// alias ls='ls -F' works.
+func (p *parser) expandAlias() error {
+	for {
+		t := p.peek()
+		if t.kind != tokWord || p.aliases == nil {
+			return nil
+		}
+		value, ok := p.aliases[t.val]
+		if !ok || slices.Contains(t.fromAliases, t.val) {
+			return nil
+		}
+
+		toks, err := lex(value, 1)
+		if err != nil {
+			return &SyntaxError{Pos: t.pos, Msg: "cannot expand alias " + t.val}
+		}
+		toks = toks[:len(toks)-1] // drop the EOF
+
+		from := append(slices.Clone(t.fromAliases), t.val)
+		for i := range toks {
+			toks[i].pos = t.pos
+			toks[i].fromAliases = from
+		}
+		if n := len(toks); n > 0 {
+			toks[n-1].expandNext = strings.TrimRight(value, " \t") != value
+		}
+		p.toks = slices.Replace(p.toks, p.i, p.i+1, toks...)
+	}
+}
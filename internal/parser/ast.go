@@ -0,0 +1,381 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Pos is a position in the source, counting lines and columns from 1
+type Pos struct {
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	if p.Line > 1 {
+		return fmt.Sprintf("line %d, column %d", p.Line, p.Col)
+	}
+	return fmt.Sprintf("column %d", p.Col)
+}
+
+// Word is a word as typed, with its quotes, escapes and expansions still in
+// place. Expanding it is up to whoever runs the command.
+type Word struct {
+	Raw string
+	Pos Pos
+}
+
+func (w Word) String() string {
+	return w.Raw
+}
+
+// Redirect is a single I/O redirection such as 2>&1 or >>log
+type Redirect struct {
+	Fd     int    // descriptor being redirected, -1 for &> which targets both 1 and 2
+	Op     string // one of < > >> >| <& >& &> &>>
+	Target Word   // file name, or descriptor number / "-" for <& and >&
+	Pos    Pos
+}
+
+func (r *Redirect) String() string {
+	switch {
+	case r.Fd < 0:
+		return r.Op + r.Target.Raw
+	case (r.Fd == 0 && r.Op[0] == '<') || (r.Fd == 1 && r.Op[0] == '>'):
+		return r.Op + r.Target.Raw
+	default:
+		return strconv.Itoa(r.Fd) + r.Op + r.Target.Raw
+	}
+}
+
+// Command is any command that can be a stage of a pipeline
+type Command interface {
+	Position() Pos
+	String() string
+}
+
+// SimpleCommand is a command name with its arguments, optionally preceded
+// by assignments and with redirections anywhere
+type SimpleCommand struct {
+	Assigns []Word // NAME=value words in front of the command
+	Words   []Word
+	Redirs  []*Redirect
+	Pos     Pos
+}
+
+func (c *SimpleCommand) Position() Pos {
+	return c.Pos
+}
+
+func (c *SimpleCommand) String() string {
+	var words []string
+	for _, w := range c.Assigns {
+		words = append(words, w.Raw)
+	}
+	for _, w := range c.Words {
+		words = append(words, w.Raw)
+	}
+	for _, r := range c.Redirs {
+		words = append(words, r.String())
+	}
+	return strings.Join(words, " ")
+}
+
+// Compound is a command with a body of its own, such as if or while.
+// Redirections written after it apply to all of it.
+type Compound interface {
+	Command
+	Redirects() []*Redirect
+}
+
+// Redirected holds the redirections of a compound command
+type Redirected struct {
+	Redirs []*Redirect
+}
+
+func (r *Redirected) Redirects() []*Redirect {
+	return r.Redirs
+}
+
+func (r *Redirected) redirected() *Redirected {
+	return r
+}
+
+// suffix renders the redirections to follow the command they belong to
+func (r *Redirected) suffix() string {
+	var sb strings.Builder
+	for _, rd := range r.Redirs {
+		sb.WriteString(" " + rd.String())
+	}
+	return sb.String()
+}
+
+// body renders l as the body of a compound command, each command
+// terminated by ";" or "&"
+func body(l *List) string {
+	s := l.String()
+	if n := len(l.Items); n > 0 && !l.Items[n-1].Background {
+		s += ";"
+	}
+	return s
+}
+
+// CondBranch is a condition and the commands run when it succeeds
+type CondBranch struct {
+	Cond *List
+	Body *List
+}
+
+// IfClause is if ... then ... [elif ... then ...] [else ...] fi
+type IfClause struct {
+	Branches []CondBranch // the if branch followed by any elif branches
+	Else     *List        // nil without an else part
+	Pos      Pos
+	Redirected
+}
+
+func (c *IfClause) Position() Pos {
+	return c.Pos
+}
+
+func (c *IfClause) String() string {
+	var sb strings.Builder
+	for i, b := range c.Branches {
+		if i == 0 {
+			sb.WriteString("if ")
+		} else {
+			sb.WriteString(" elif ")
+		}
+		sb.WriteString(body(b.Cond) + " then " + body(b.Body))
+	}
+	if c.Else != nil {
+		sb.WriteString(" else " + body(c.Else))
+	}
+	sb.WriteString(" fi")
+	return sb.String() + c.suffix()
+}
+
+// WhileClause is while ... do ... done, or until ... do ... done with
+// Until set
+type WhileClause struct {
+	Until bool
+	Cond  *List
+	Body  *List
+	Pos   Pos
+	Redirected
+}
+
+func (c *WhileClause) Position() Pos {
+	return c.Pos
+}
+
+func (c *WhileClause) String() string {
+	keyword := "while"
+	if c.Until {
+		keyword = "until"
+	}
+	return keyword + " " + body(c.Cond) + " do " + body(c.Body) + " done" + c.suffix()
+}
+
+// ForClause is for NAME [in WORD ...]; do ... done. Without "in" it loops
+// over the positional parameters.
+type ForClause struct {
+	Name  string
+	In    bool
+	Words []Word
+	Body  *List
+	Pos   Pos
+	Redirected
+}
+
+func (c *ForClause) Position() Pos {
+	return c.Pos
+}
+
+func (c *ForClause) String() string {
+	s := "for " + c.Name
+	if c.In {
+		s += " in"
+		for _, w := range c.Words {
+			s += " " + w.Raw
+		}
+	}
+	return s + "; do " + body(c.Body) + " done" + c.suffix()
+}
+
+// CaseItem is one pattern list of a case command and its commands
+type CaseItem struct {
+	Patterns []Word
+	Body     *List
+}
+
+// CaseClause is case WORD in PATTERN) ...;; ... esac
+type CaseClause struct {
+	Word  Word
+	Items []*CaseItem
+	Pos   Pos
+	Redirected
+}
+
+func (c *CaseClause) Position() Pos {
+	return c.Pos
+}
+
+func (c *CaseClause) String() string {
+	var sb strings.Builder
+	sb.WriteString("case " + c.Word.Raw + " in")
+	for _, item := range c.Items {
+		patterns := make([]string, len(item.Patterns))
+		for i, w := range item.Patterns {
+			patterns[i] = w.Raw
+		}
+		sb.WriteString(" " + strings.Join(patterns, "|") + ") ")
+		if len(item.Body.Items) > 0 {
+			sb.WriteString(item.Body.String() + " ")
+		}
+		sb.WriteString(";;")
+	}
+	sb.WriteString(" esac")
+	return sb.String() + c.suffix()
+}
+
+// Group is { ...; }, a list run as a unit in the shell itself
+type Group struct {
+	Body *List
+	Pos  Pos
+	Redirected
+}
+
+func (c *Group) Position() Pos {
+	return c.Pos
+}
+
+func (c *Group) String() string {
+	return "{ " + body(c.Body) + " }" + c.suffix()
+}
+
+// Subshell is ( ... ), a list run in a copy of the shell so that nothing it
+// changes, including the working directory, outlives it
+type Subshell struct {
+	Body *List
+	Pos  Pos
+	Redirected
+}
+
+func (c *Subshell) Position() Pos {
+	return c.Pos
+}
+
+func (c *Subshell) String() string {
+	return "(" + c.Body.String() + ")" + c.suffix()
+}
+
+// ArithCommand is ((expression)), which succeeds when the expression is
+// not 0
+type ArithCommand struct {
+	Expr string // as typed, to be expanded before evaluating
+	Pos  Pos
+	Redirected
+}
+
+func (c *ArithCommand) Position() Pos {
+	return c.Pos
+}
+
+func (c *ArithCommand) String() string {
+	return "((" + c.Expr + "))" + c.suffix()
+}
+
+// CondCommand is [[ expression ]], which tests files, strings and numbers
+// like the test builtin, without splitting or globbing its words
+type CondCommand struct {
+	Expr string // as typed, blanks around it included
+	Pos  Pos
+	Redirected
+}
+
+func (c *CondCommand) Position() Pos {
+	return c.Pos
+}
+
+func (c *CondCommand) String() string {
+	return "[[" + c.Expr + "]]"
+}
+
+// FuncDecl defines a function: name() followed by a compound command,
+// usually a group
+type FuncDecl struct {
+	Name string
+	Body Compound
+	Pos  Pos
+}
+
+func (f *FuncDecl) Position() Pos {
+	return f.Pos
+}
+
+func (f *FuncDecl) String() string {
+	return f.Name + " () " + f.Body.String()
+}
+
+// Pipeline is a chain of commands where each command's stdout feeds the
+// next command's stdin. With Bang set its status is negated, as in ! cmd.
+type Pipeline struct {
+	Bang     bool
+	Commands []Command
+	Pos      Pos
+}
+
+func (p *Pipeline) String() string {
+	parts := make([]string, len(p.Commands))
+	for i, c := range p.Commands {
+		parts[i] = c.String()
+	}
+	s := strings.Join(parts, " | ")
+	if p.Bang {
+		s = "! " + s
+	}
+	return s
+}
+
+// AndOr is a chain of pipelines joined by "&&" and "||". The operators have
+// equal precedence and associate to the left, so `a && b || c` runs c
+// whenever a or b failed.
+type AndOr struct {
+	Pipelines  []*Pipeline
+	Ops        []string // Ops[i] joins Pipelines[i] and Pipelines[i+1]
+	Background bool     // terminated by "&"
+}
+
+func (a *AndOr) String() string {
+	var sb strings.Builder
+	for i, p := range a.Pipelines {
+		if i > 0 {
+			sb.WriteString(" " + a.Ops[i-1] + " ")
+		}
+		sb.WriteString(p.String())
+	}
+	return sb.String()
+}
+
+// List is a sequence of and-or lists separated by ";", "&" or newlines
+type List struct {
+	Items []*AndOr
+}
+
+func (l *List) String() string {
+	var sb strings.Builder
+	for i, a := range l.Items {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(a.String())
+		if a.Background {
+			sb.WriteString(" &")
+		} else if i < len(l.Items)-1 {
+			sb.WriteString(";")
+		}
+	}
+	return sb.String()
+}
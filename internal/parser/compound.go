@@ -0,0 +1,343 @@
+package parser
+
+import (
+	"strings"
+	"unicode"
+)
+
+// compoundWords are the reserved words that start a compound command
+var compoundWords = map[string]bool{
+	"if": true, "while": true, "until": true, "for": true, "case": true, "{": true,
+}
+
+// terminators are the reserved words that end a list when they appear
+// where a command would start
+var terminators = map[string]bool{
+	"then": true, "elif": true, "else": true, "fi": true,
+	"do": true, "done": true, "esac": true, "}": true,
+}
+
+// IsReservedWord reports whether w is one of the words the shell gives a
+// meaning of its own where a command would start, such as if or done
+func IsReservedWord(w string) bool {
+	return compoundWords[w] || terminators[w] || w == "!" || w == "in" || w == "function" ||
+		w == "[[" || w == "]]"
+}
+
+// startsCompound reports whether t is the first token of a compound command
+func startsCompound(t token) bool {
+	switch t.kind {
+	case tokWord:
+		return compoundWords[t.val]
+	case tokArith, tokCond:
+		return true
+	case tokOp:
+		return t.val == "("
+	}
+	return false
+}
+
+// isReserved reports whether t is the unquoted reserved word w
+func isReserved(t token, w string) bool {
+	return t.kind == tokWord && t.val == w
+}
+
+// expect consumes the reserved word w
+func (p *parser) expect(w string) error {
+	if t := p.peek(); !isReserved(t, w) {
+		return p.unexpected(t)
+	}
+	p.next()
+	return nil
+}
+
+// compoundList parses the non-empty list that makes up part of a compound
+// command
+func (p *parser) compoundList() (*List, error) {
+	l, err := p.list()
+	if err != nil {
+		return nil, err
+	}
+	if len(l.Items) == 0 {
+		return nil, p.unexpected(p.peek())
+	}
+	return l, nil
+}
+
+// compound parses the compound command starting with the reserved word at
+// the current token, along with any redirections that follow it
+func (p *parser) compound() (Compound, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+
+	var c interface {
+		Compound
+		redirected() *Redirected
+	}
+	var err error
+
+	switch t := p.peek(); {
+	case t.kind == tokArith:
+		c = &ArithCommand{Expr: t.val, Pos: p.next().pos}
+	case t.kind == tokCond:
+		c = &CondCommand{Expr: t.val, Pos: p.next().pos}
+	case t.kind == tokOp && t.val == "(":
+		c, err = p.subshell()
+	case t.val == "if":
+		c, err = p.ifClause()
+	case t.val == "while", t.val == "until":
+		c, err = p.whileClause()
+	case t.val == "for":
+		c, err = p.forClause()
+	case t.val == "case":
+		c, err = p.caseClause()
+	case t.val == "{":
+		c, err = p.group()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for t := p.peek(); t.kind == tokOp && isRedirectOp(t.val); t = p.peek() {
+		r, err := p.redirect()
+		if err != nil {
+			return nil, err
+		}
+		c.redirected().Redirs = append(c.redirected().Redirs, r)
+	}
+	return c, nil
+}
+
+func (p *parser) group() (*Group, error) {
+	c := &Group{Pos: p.next().pos}
+	var err error
+	if c.Body, err = p.compoundList(); err != nil {
+		return nil, err
+	}
+	return c, p.expect("}")
+}
+
+func (p *parser) subshell() (*Subshell, error) {
+	c := &Subshell{Pos: p.next().pos}
+	var err error
+	if c.Body, err = p.compoundList(); err != nil {
+		return nil, err
+	}
+	if !p.isOp(")") {
+		return nil, p.unexpected(p.peek())
+	}
+	p.next()
+	return c, nil
+}
+
+func (p *parser) ifClause() (*IfClause, error) {
+	c := &IfClause{Pos: p.next().pos}
+	for {
+		cond, err := p.compoundList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		body, err := p.compoundList()
+		if err != nil {
+			return nil, err
+		}
+		c.Branches = append(c.Branches, CondBranch{Cond: cond, Body: body})
+
+		if !isReserved(p.peek(), "elif") {
+			break
+		}
+		p.next()
+	}
+
+	if isReserved(p.peek(), "else") {
+		p.next()
+		body, err := p.compoundList()
+		if err != nil {
+			return nil, err
+		}
+		c.Else = body
+	}
+	return c, p.expect("fi")
+}
+
+func (p *parser) whileClause() (*WhileClause, error) {
+	t := p.next()
+	c := &WhileClause{Until: t.val == "until", Pos: t.pos}
+
+	var err error
+	if c.Cond, err = p.compoundList(); err != nil {
+		return nil, err
+	}
+	if c.Body, err = p.doGroup(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// doGroup parses do ... done
+func (p *parser) doGroup() (*List, error) {
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+	body, err := p.compoundList()
+	if err != nil {
+		return nil, err
+	}
+	return body, p.expect("done")
+}
+
+func (p *parser) forClause() (*ForClause, error) {
+	c := &ForClause{Pos: p.next().pos}
+
+	name := p.peek()
+	if name.kind != tokWord || !IsName(name.val) {
+		if name.kind == tokWord {
+			return nil, &SyntaxError{Pos: name.pos, Msg: "`" + name.val + "': not a valid identifier"}
+		}
+		return nil, p.unexpected(name)
+	}
+	p.next()
+	c.Name = name.val
+
+	p.skipNewlines()
+	if isReserved(p.peek(), "in") {
+		p.next()
+		c.In = true
+		for p.peek().kind == tokWord {
+			t := p.next()
+			c.Words = append(c.Words, Word{Raw: t.val, Pos: t.pos})
+		}
+	}
+
+	// The word list ends with ";" or a newline before do
+	if p.isOp(";") {
+		p.next()
+	}
+	p.skipNewlines()
+
+	var err error
+	if c.Body, err = p.doGroup(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (p *parser) caseClause() (*CaseClause, error) {
+	c := &CaseClause{Pos: p.next().pos}
+
+	word := p.next()
+	if word.kind != tokWord {
+		return nil, p.unexpected(word)
+	}
+	c.Word = Word{Raw: word.val, Pos: word.pos}
+
+	p.skipNewlines()
+	if err := p.expect("in"); err != nil {
+		return nil, err
+	}
+	p.skipNewlines()
+
+	for !isReserved(p.peek(), "esac") {
+		item := &CaseItem{}
+
+		if p.isOp("(") {
+			p.next()
+		}
+		for {
+			t := p.next()
+			if t.kind != tokWord {
+				return nil, p.unexpected(t)
+			}
+			item.Patterns = append(item.Patterns, Word{Raw: t.val, Pos: t.pos})
+			if !p.isOp("|") {
+				break
+			}
+			p.next()
+		}
+		if !p.isOp(")") {
+			return nil, p.unexpected(p.peek())
+		}
+		p.next()
+
+		body, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		item.Body = body
+		c.Items = append(c.Items, item)
+
+		// The last item may leave out its ;;
+		if p.isOp(";;") {
+			p.next()
+			p.skipNewlines()
+		} else if !isReserved(p.peek(), "esac") {
+			return nil, p.unexpected(p.peek())
+		}
+	}
+	p.next()
+	return c, nil
+}
+
+// isFuncName reports whether s may name a function. Besides variable names
+// this allows names like git-root or my.func, but nothing that would need
+// quoting or expanding.
+func isFuncName(s string) bool {
+	if s == "" || terminators[s] || IsAssignment(s) || isDigits(s) {
+		return false
+	}
+	for _, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-.:", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// startsFuncDecl reports whether the current token starts name()
+func (p *parser) startsFuncDecl() bool {
+	t := p.peek()
+	open, close := p.peekAt(1), p.peekAt(2)
+	return isFuncName(t.val) && open.kind == tokOp && open.val == "(" &&
+		close.kind == tokOp && close.val == ")"
+}
+
+// funcDecl parses name() followed by the function body, or the same with
+// the function keyword in front, in which case the parentheses are optional
+func (p *parser) funcDecl() (*FuncDecl, error) {
+	f := &FuncDecl{Pos: p.peek().pos}
+	keyword := isReserved(p.peek(), "function")
+	if keyword {
+		p.next()
+	}
+
+	name := p.next()
+	if name.kind != tokWord {
+		return nil, p.unexpected(name)
+	}
+	if !isFuncName(name.val) {
+		return nil, &SyntaxError{Pos: name.pos, Msg: "`" + name.val + "': not a valid function name"}
+	}
+	f.Name = name.val
+
+	if p.isOp("(") || !keyword {
+		p.next()
+		if !p.isOp(")") {
+			return nil, p.unexpected(p.peek())
+		}
+		p.next()
+	}
+
+	p.skipNewlines()
+	if t := p.peek(); !startsCompound(t) {
+		return nil, p.unexpected(t)
+	}
+	body, err := p.compound()
+	if err != nil {
+		return nil, err
+	}
+	f.Body = body
+	return f, nil
+}
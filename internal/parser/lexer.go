@@ -0,0 +1,428 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokWord    tokenKind = iota
+	tokOp                // unquoted operator such as | or >>
+	tokNewline           // end of a line, which ends a command like ;
+	tokArith             // ((expression)), with val holding the expression
+	tokCond              // [[ expression ]], with val holding the expression
+	tokEOF
+)
+
+// token is a single lexical unit of the source
+type token struct {
+	kind  tokenKind
+	val   string
+	pos   Pos
+	ionum string // fd number written right before a redirection, as in 2>
+
+	// fromAliases lists the aliases whose expansion produced the token,
+	// which keeps them from expanding again inside it
+	fromAliases []string
+	// expandNext is set on the last word of an alias ending in a blank,
+	// which makes the word after it eligible for alias expansion too
+	expandNext bool
+}
+
+// describe names t the way syntax errors refer to it
+func (t token) describe() string {
+	switch t.kind {
+	case tokNewline:
+		return "newline"
+	case tokEOF:
+		return "end of input"
+	case tokArith:
+		return "((" + t.val + "))"
+	case tokCond:
+		return "[[" + t.val + "]]"
+	}
+	return t.val
+}
+
+// operators lists the shell operators, longest first so that the first
+// match is the right one
+var operators = []string{"&>>", "&&", "&>", "||", ";;", ">>", ">&", ">|", "<&", "|", "&", ";", "<", ">", "(", ")"}
+
+// scanOp returns the longest operator at the start of rs
+func scanOp(rs []rune) string {
+	prefix := string(rs[:min(3, len(rs))])
+	for _, op := range operators {
+		if strings.HasPrefix(prefix, op) {
+			return op
+		}
+	}
+	return string(rs[0])
+}
+
+func isOpStart(r rune) bool {
+	return strings.ContainsRune("|&;<>()", r)
+}
+
+func isRedirectOp(op string) bool {
+	switch op {
+	case "<", ">", ">>", ">|", "<&", ">&", "&>", "&>>":
+		return true
+	}
+	return false
+}
+
+// lexer splits source into tokens. Words are kept exactly as typed, quotes
+// and all, so that expansion can later tell quoted text from unquoted.
+type lexer struct {
+	src        []rune
+	lineStarts []int // index in src where each line begins
+	firstLine  int   // number of the first line of src in its file
+	toks       []token
+	// cmdStart is set where a command may begin, the only place where
+	// (( starts an arithmetic command and [[ a conditional one
+	cmdStart bool
+}
+
+// commandStarters are the words after which a command may begin
+var commandStarters = map[string]bool{
+	"if": true, "then": true, "elif": true, "else": true, "while": true,
+	"until": true, "do": true, "!": true, "{": true,
+}
+
+// lex splits src, whose first line is line firstLine of its file, into
+// tokens
+func lex(src string, firstLine int) ([]token, error) {
+	l := &lexer{src: []rune(src), lineStarts: []int{0}, firstLine: firstLine, cmdStart: true}
+	for i, r := range l.src {
+		if r == '\n' {
+			l.lineStarts = append(l.lineStarts, i+1)
+		}
+	}
+	if err := l.run(); err != nil {
+		return nil, err
+	}
+	return l.toks, nil
+}
+
+// pos returns the position of src[i]
+func (l *lexer) pos(i int) Pos {
+	line := sort.Search(len(l.lineStarts), func(n int) bool { return l.lineStarts[n] > i })
+	return Pos{Line: l.firstLine + line - 1, Col: i - l.lineStarts[line-1] + 1}
+}
+
+func (l *lexer) emit(kind tokenKind, val string, start int) {
+	l.toks = append(l.toks, token{kind: kind, val: val, pos: l.pos(start)})
+	switch kind {
+	case tokWord:
+		l.cmdStart = commandStarters[val]
+	case tokOp:
+		l.cmdStart = !isRedirectOp(val)
+	default:
+		l.cmdStart = true
+	}
+}
+
+func (l *lexer) run() error {
+	src := l.src
+	n := len(src)
+
+	for i := 0; i < n; {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+
+		case c == '\\' && i+1 < n && src[i+1] == '\n':
+			// A line continuation between words, which needs another line
+			// when it is the last thing in the source
+			if i+2 == n {
+				return incomplete(l.pos(i), "unexpected end of input after `\\'")
+			}
+			i += 2
+
+		case c == '\n':
+			l.emit(tokNewline, "\n", i)
+			i++
+
+		case c == '#':
+			for i < n && src[i] != '\n' {
+				i++
+			}
+
+		case c == '(' && l.cmdStart && i+1 < n && src[i+1] == '(':
+			end, ok, err := l.arith(i)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				// Two subshells opening at once rather than ((expr))
+				l.emit(tokOp, "(", i)
+				i++
+				continue
+			}
+			l.emit(tokArith, string(src[i+2:end-2]), i)
+			i = end
+
+		case c == '[' && l.cmdStart && i+1 < n && src[i+1] == '[' && (i+2 == n || isBlank(src[i+2])):
+			end, err := l.cond(i)
+			if err != nil {
+				return err
+			}
+			l.emit(tokCond, string(src[i+2:end-2]), i)
+			i = end
+
+		case isOpStart(c):
+			op := scanOp(src[i:])
+			l.emit(tokOp, op, i)
+			i += len([]rune(op))
+
+		default:
+			end, word, err := l.word(i)
+			if err != nil {
+				return err
+			}
+			// Digits right before < or > name the descriptor to redirect
+			if end < n && (src[end] == '<' || src[end] == '>') && isDigits(word) {
+				op := scanOp(src[end:])
+				l.toks = append(l.toks, token{kind: tokOp, val: op, pos: l.pos(i), ionum: word})
+				i = end + len(op)
+				continue
+			}
+			l.emit(tokWord, word, i)
+			i = end
+		}
+	}
+
+	l.emit(tokEOF, "", n)
+	return nil
+}
+
+// word scans the word starting at src[i] and returns the index just past
+// it along with its raw text, minus any line continuations
+func (l *lexer) word(i int) (int, string, error) {
+	src := l.src
+	n := len(src)
+	var sb strings.Builder
+
+	for i < n {
+		c := src[i]
+		if c == ' ' || c == '\t' || c == '\n' || isOpStart(c) {
+			break
+		}
+		switch c {
+		case '\\':
+			if i+1 >= n || src[i+1] == '\n' && i+2 == n {
+				return 0, "", incomplete(l.pos(i), "unexpected end of input after `\\'")
+			}
+			if src[i+1] != '\n' {
+				sb.WriteRune(c)
+				sb.WriteRune(src[i+1])
+			}
+			i += 2
+
+		case '\'', '"', '`', '$':
+			end, ok := skip(src, i)
+			if !ok {
+				return 0, "", incomplete(l.pos(i), unterminated(src[i:]))
+			}
+			sb.WriteString(string(src[i:end]))
+			i = end
+
+		default:
+			sb.WriteRune(c)
+			i++
+		}
+	}
+	return i, sb.String(), nil
+}
+
+// arith scans the arithmetic command starting with the (( at src[i] and
+// returns the index just past its closing )). It reports false when the
+// parentheses close in some other way, as in ((a) | b).
+func (l *lexer) arith(i int) (int, bool, error) {
+	src := l.src
+	depth := 0
+	for j := i + 2; j < len(src); j++ {
+		switch src[j] {
+		case '\\', '\'', '"', '`', '$':
+			end, ok := skip(src, j)
+			if !ok {
+				return 0, false, incomplete(l.pos(j), unterminated(src[j:]))
+			}
+			j = end - 1
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			if j+1 < len(src) && src[j+1] == ')' {
+				return j + 2, true, nil
+			}
+			return 0, false, nil
+		}
+	}
+	return 0, false, incomplete(l.pos(i), "unexpected end of input while looking for matching `))'")
+}
+
+// cond scans the conditional command starting with the [[ at src[i] and
+// returns the index just past its closing ]], which has to be a word of its
+// own. The operators inside are left for the shell to sort out when it runs
+// the command, since < and > compare strings there and && and || join
+// expressions.
+func (l *lexer) cond(i int) (int, error) {
+	src := l.src
+	n := len(src)
+	for j := i + 2; j < n; j++ {
+		switch src[j] {
+		case '\\', '\'', '"', '`', '$':
+			end, ok := skip(src, j)
+			if !ok {
+				return 0, incomplete(l.pos(j), unterminated(src[j:]))
+			}
+			j = end - 1
+		case ']':
+			if isBlank(src[j-1]) && j+1 < n && src[j+1] == ']' &&
+				(j+2 == n || isBlank(src[j+2]) || isOpStart(src[j+2])) {
+				return j + 2, nil
+			}
+		}
+	}
+	return 0, incomplete(l.pos(i), "unexpected end of input while looking for matching `]]'")
+}
+
+// isBlank reports whether r separates words
+func isBlank(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n'
+}
+
+// unterminated describes what is missing at the end of the input when the
+// quote or substitution at the start of rs is never closed
+func unterminated(rs []rune) string {
+	closing := string(rs[0])
+	if len(rs) > 1 && rs[0] == '$' {
+		switch rs[1] {
+		case '(':
+			closing = ")"
+		case '{':
+			closing = "}"
+		}
+	}
+	return "unexpected end of input while looking for matching `" + closing + "'"
+}
+
+// SkipQuoted returns the index just past the quoted string, parameter or
+// command substitution that starts at rs[i], which is one of \ ' " ` or $.
+// If it is never closed the result is len(rs).
+func SkipQuoted(rs []rune, i int) int {
+	end, _ := skip(rs, i)
+	return end
+}
+
+// skip does the work of SkipQuoted, also reporting whether what started at
+// rs[i] was properly closed
+func skip(rs []rune, i int) (int, bool) {
+	n := len(rs)
+
+	switch rs[i] {
+	case '\\':
+		if i+1 < n {
+			return i + 2, true
+		}
+		return n, false
+
+	case '\'':
+		for j := i + 1; j < n; j++ {
+			if rs[j] == '\'' {
+				return j + 1, true
+			}
+		}
+
+	case '"':
+		for j := i + 1; j < n; j++ {
+			switch rs[j] {
+			case '\\':
+				j++
+			case '$', '`':
+				end, ok := skip(rs, j)
+				if !ok {
+					return n, false
+				}
+				j = end - 1
+			case '"':
+				return j + 1, true
+			}
+		}
+
+	case '`':
+		for j := i + 1; j < n; j++ {
+			switch rs[j] {
+			case '\\':
+				j++
+			case '`':
+				return j + 1, true
+			}
+		}
+
+	case '$':
+		if i+1 >= n {
+			return n, true
+		}
+		var open, close rune
+		switch rs[i+1] {
+		case '{':
+			open, close = '{', '}'
+		case '(':
+			open, close = '(', ')'
+		default:
+			return i + 1, true
+		}
+		if open == '(' && (i+2 >= n || rs[i+2] != '(') {
+			// Counting parentheses goes wrong on the patterns of a case
+			// and on quotes in comments, so $(...) ends at the first )
+			// whose body parses instead
+			for j := i + 2; j < n; j++ {
+				if rs[j] == ')' {
+					if _, err := Parse(string(rs[i+2 : j])); err == nil {
+						return j + 1, true
+					}
+				}
+			}
+		}
+		// ${...} and $(...) nest, and may contain quotes of their own
+		depth := 0
+		for j := i + 1; j < n; j++ {
+			switch rs[j] {
+			case '\\', '\'', '"', '`', '$':
+				end, ok := skip(rs, j)
+				if !ok {
+					return n, false
+				}
+				j = end - 1
+			case open:
+				depth++
+			case close:
+				depth--
+				if depth == 0 {
+					return j + 1, true
+				}
+			}
+		}
+	}
+	return n, false
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,331 @@
+// Package parser turns shell source into a syntax tree. Words are kept as
+// typed; expanding and running them is left to the command package.
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxFd caps the file descriptor numbers a redirection may name
+const maxFd = 255
+
+// ErrIncomplete is matched by the error for input that stops in the middle
+// of a command, such as after a "|" or inside quotes. An interactive reader
+// can read another line, append it and parse again.
+var ErrIncomplete = errors.New("incomplete input")
+
+// SyntaxError is input that cannot be parsed
+type SyntaxError struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error: %s at %s", e.Msg, e.Pos)
+}
+
+// incompleteError is a syntax error that more input could fix
+type incompleteError struct {
+	pos Pos // where the unfinished construct starts
+	msg string
+}
+
+func (e *incompleteError) Error() string {
+	return "syntax error: " + e.msg
+}
+
+func (e *incompleteError) Is(target error) bool {
+	return target == ErrIncomplete
+}
+
+func incomplete(pos Pos, msg string) error {
+	return &incompleteError{pos: pos, msg: msg}
+}
+
+// IncompleteAt returns where the unfinished construct starts when err is
+// about input that stops in the middle of a command
+func IncompleteAt(err error) (Pos, bool) {
+	var inc *incompleteError
+	if errors.As(err, &inc) {
+		return inc.pos, true
+	}
+	return Pos{}, false
+}
+
+// IsName reports whether s is a valid variable name
+func IsName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsAssignment reports whether a raw word has the form NAME=value
+func IsAssignment(word string) bool {
+	for i, r := range word {
+		if r == '=' {
+			return i > 0 && IsName(word[:i])
+		}
+	}
+	return false
+}
+
+type parser struct {
+	toks    []token
+	i       int
+	aliases map[string]string
+	// depth counts the compound commands being parsed, and start is where
+	// the outermost command being parsed begins, which is what input that
+	// ends too early leaves unfinished
+	depth int
+	start Pos
+}
+
+// Parse parses a complete piece of source, which may span several lines
+func Parse(src string) (*List, error) {
+	return ParseWithAliases(src, nil)
+}
+
+// ParseWithAliases is Parse with alias expansion: the first word of a
+// command is replaced by its value in aliases
+func ParseWithAliases(src string, aliases map[string]string) (*List, error) {
+	return ParseAt(src, 1, aliases)
+}
+
+// ParseAt is ParseWithAliases for source that starts at the given line of
+// a file, so that positions count lines the way the file does
+func ParseAt(src string, line int, aliases map[string]string) (*List, error) {
+	toks, err := lex(src, line)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, aliases: aliases}
+	l, err := p.list()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.unexpected(t)
+	}
+	return l, nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+// peekAt returns the token n places after the current one
+func (p *parser) peekAt(n int) token {
+	return p.toks[min(p.i+n, len(p.toks)-1)]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.val == op
+}
+
+func (p *parser) skipNewlines() {
+	for p.peek().kind == tokNewline {
+		p.next()
+	}
+}
+
+// unexpected reports t where it does not belong. Running out of input is
+// never wrong as such, it only means the command isn't finished yet.
+func (p *parser) unexpected(t token) error {
+	if t.kind == tokEOF {
+		pos := p.start
+		if pos == (Pos{}) {
+			pos = t.pos
+		}
+		return incomplete(pos, "unexpected end of input")
+	}
+	return &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("unexpected token '%s'", t.describe())}
+}
+
+// list parses and-or lists separated by ";", "&" or newlines, up to the
+// first token that cannot start a command, which is left for the caller
+func (p *parser) list() (*List, error) {
+	l := &List{}
+	p.skipNewlines()
+	for p.startsCommand(p.peek()) {
+		a, err := p.andOr()
+		if err != nil {
+			return nil, err
+		}
+		l.Items = append(l.Items, a)
+
+		switch t := p.peek(); {
+		case t.kind == tokOp && (t.val == ";" || t.val == "&"):
+			a.Background = t.val == "&"
+			p.next()
+		case t.kind == tokNewline, t.kind == tokEOF:
+		case t.kind == tokOp && (t.val == ";;" || t.val == ")"):
+			// The end of a case item or subshell, which the caller deals with
+			return l, nil
+		default:
+			return nil, p.unexpected(t)
+		}
+		p.skipNewlines()
+	}
+	return l, nil
+}
+
+// startsCommand reports whether t can be the first token of a command
+func (p *parser) startsCommand(t token) bool {
+	switch t.kind {
+	case tokWord:
+		return !terminators[t.val]
+	case tokArith, tokCond:
+		return true
+	case tokOp:
+		return isRedirectOp(t.val) || t.val == "("
+	}
+	return false
+}
+
+func (p *parser) andOr() (*AndOr, error) {
+	if p.depth == 0 {
+		p.start = p.peek().pos
+	}
+	a := &AndOr{}
+	for {
+		pl, err := p.pipeline()
+		if err != nil {
+			return nil, err
+		}
+		a.Pipelines = append(a.Pipelines, pl)
+
+		if !p.isOp("&&") && !p.isOp("||") {
+			return a, nil
+		}
+		a.Ops = append(a.Ops, p.next().val)
+		p.skipNewlines()
+	}
+}
+
+func (p *parser) pipeline() (*Pipeline, error) {
+	pl := &Pipeline{Pos: p.peek().pos}
+	if t := p.peek(); t.kind == tokWord && t.val == "!" {
+		pl.Bang = true
+		p.next()
+	}
+	for {
+		c, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		pl.Commands = append(pl.Commands, c)
+
+		if !p.isOp("|") {
+			return pl, nil
+		}
+		p.next()
+		p.skipNewlines()
+	}
+}
+
+func (p *parser) command() (Command, error) {
+	if err := p.expandAlias(); err != nil {
+		return nil, err
+	}
+	if startsCompound(p.peek()) {
+		return p.compound()
+	}
+	if t := p.peek(); t.kind == tokWord && (t.val == "function" || p.startsFuncDecl()) {
+		return p.funcDecl()
+	}
+	return p.simpleCommand()
+}
+
+// simpleCommand parses assignments, words and redirections up to the next
+// operator
+func (p *parser) simpleCommand() (*SimpleCommand, error) {
+	c := &SimpleCommand{Pos: p.peek().pos}
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == tokWord:
+			p.next()
+			w := Word{Raw: t.val, Pos: t.pos}
+			if len(c.Words) == 0 && IsAssignment(t.val) {
+				c.Assigns = append(c.Assigns, w)
+			} else {
+				c.Words = append(c.Words, w)
+			}
+			// The command name may follow assignments, and an alias ending
+			// in a blank lets the next word be an alias too
+			if (len(c.Words) == 0 || t.expandNext) && p.peek().kind == tokWord {
+				if err := p.expandAlias(); err != nil {
+					return nil, err
+				}
+			}
+
+		case t.kind == tokOp && isRedirectOp(t.val):
+			r, err := p.redirect()
+			if err != nil {
+				return nil, err
+			}
+			c.Redirs = append(c.Redirs, r)
+
+		default:
+			if len(c.Assigns) == 0 && len(c.Words) == 0 && len(c.Redirs) == 0 {
+				return nil, p.unexpected(t)
+			}
+			return c, nil
+		}
+	}
+}
+
+// redirect parses a redirection operator and its target
+func (p *parser) redirect() (*Redirect, error) {
+	op := p.next()
+	target := p.peek()
+	if target.kind != tokWord {
+		if target.kind == tokEOF {
+			// Unlike a dangling "|", a dangling ">" is never continued
+			target.kind = tokNewline
+		}
+		return nil, p.unexpected(target)
+	}
+	p.next()
+
+	r := &Redirect{Op: op.val, Target: Word{Raw: target.val, Pos: target.pos}, Pos: op.pos}
+	switch {
+	case op.val == "&>" || op.val == "&>>":
+		r.Fd = -1
+	case op.ionum != "":
+		fd, err := strconv.Atoi(op.ionum)
+		if err != nil || fd > maxFd {
+			return nil, &SyntaxError{Pos: op.pos, Msg: op.ionum + ": bad file descriptor"}
+		}
+		r.Fd = fd
+	case op.val[0] == '<':
+		r.Fd = 0
+	default:
+		r.Fd = 1
+	}
+
+	// >&word with a non-numeric word is the csh spelling of &>word
+	if r.Op == ">&" && r.Fd == 1 && op.ionum == "" && target.val != "-" && !isDigits(target.val) && !strings.ContainsRune(target.val, '$') {
+		r.Fd, r.Op = -1, "&>"
+	}
+	return r, nil
+}